@@ -4,68 +4,119 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/zimmski/tirion/collector"
 	"github.com/zimmski/tirion/proc"
 )
 
+// Policies for following the monitored program after its process disappeared.
+const (
+	FollowNone  = "none"  // stop monitoring
+	FollowChild = "child" // monitor the nearest descendant of the process which is still running
+	FollowWait  = "wait"  // wait for a new process given by the PID file or the name of the program
+)
+
+// Stages of the termination of an executed program which are recorded as the stop reason of a run.
+const (
+	StopExited = "exited" // the program exited on its own
+	StopNotify = "notify" // the program exited after its client was requested to stop
+	StopSignal = "signal" // the program exited after the stop signal
+	StopKill   = "kill"   // the program was killed with SIGKILL
+)
+
 type execProgram struct {
 	pid                 int32
+	pidFile             string
+	waitForName         *regexp.Regexp
+	followChildren      string
 	exec                string
 	execArguments       []string
+	execCwd             string
+	execEnv             []string
+	execEnvClear        bool
+	execStdin           string
 	limitMemory         int64
 	limitMemoryInterval int32
 	limitTime           int32
+	limitTimeNotice     int32
+	stopNotifyGrace     int32
+	stopSignal          syscall.Signal
+	stopGrace           int32
+}
+
+type derivedMetric struct {
+	index      int32
+	expression *Expression
+}
+
+type logStream struct {
+	name string
+	r    *os.File
+	w    *os.File
+}
+
+type logLine struct {
+	time   time.Time
+	stream string
+	line   string
+}
+
+type agentSource struct {
+	source  MetricSource
+	metrics []int32
+	failing bool      // states if the last read of the source failed
+	values  []float32 // values of the last successful read
 }
 
 // Agent contains the state of an agent.
 type Agent struct {
 	Tirion
-	chMessages           chan interface{}
-	cmd                  *exec.Cmd
-	interval             int32
-	l                    net.Listener
-	program              execProgram
-	metrics              []Metric
-	metricsCollector     collector.Collector
-	metricsExternal      []int32
-	metricsExternalAll   map[int32]int32
-	metricsExternalIO    map[int32]int32
-	metricsExternalStat  map[int32]int32
-	metricsExternalStatm map[int32]int32
-	metricsInternal      []int32
-	name                 string
-	run                  int32
-	sendInterval         int32
-	server               string
-	serverConn           net.Conn
-	serverClient         *httputil.ClientConn
-	subName              string
-	writerCSV            *csv.Writer
+	chMessages       chan interface{}
+	chMessagesClosed bool
+	chMessagesLock   sync.RWMutex
+	clientCount      int32 // number of connected clients so far which numbers the metric collectors of the clients. accessed atomically
+	control          agentControl
+	interval         int32
+	logTags          []*regexp.Regexp
+	program          execProgram // settings which are shared by all programs
+	processes        []*agentProcess
+	metrics          []Metric
+	metricsDerived   []derivedMetric
+	metricsExternal  []int32
+	metricsLock      sync.RWMutex // guards the metrics which a client can declare while the run is already started
+	name             string
+	paused           bool
+	run              int32
+	sendInterval     int32
+	server           string
+	settingsLock     sync.RWMutex // guards interval, paused and the limits of program which can be changed while running
+	serverConn       net.Conn
+	serverClient     *httputil.ClientConn
+	subName          string
+	writerCSV        *csv.Writer
 }
 
 // NewAgent allocates a new Agent object
-func NewAgent(name string, subName string, server string, sendInterval int32, pid int32, metrics []Metric, exec string, execArguments []string, interval int32, socket string, verbose bool, limitMemory int64, limitMemoryInterval int32, limitTime int32) *Agent {
+func NewAgent(name string, subName string, server string, sendInterval int32, programs []AgentProgram, followChildren string, metrics []Metric, execEnv []string, execEnvClear bool, execCwd string, execStdin string, interval int32, verbose bool, limitMemory int64, limitMemoryInterval int32, limitTime int32, limitTimeNotice int32, stopNotifyGrace int32, stopSignal syscall.Signal, stopGrace int32, logTags []*regexp.Regexp, control string) *Agent {
 	var rBadChars = regexp.MustCompile(`[\/]`)
 
 	name = rBadChars.ReplaceAllLiteralString(name, "-")
 
-	return &Agent{
+	var a = &Agent{
 		Tirion: Tirion{
-			socket:    socket,
 			verbose:   verbose,
 			logPrefix: "[agent]",
 		},
@@ -73,23 +124,43 @@ func NewAgent(name string, subName string, server string, sendInterval int32, pi
 		subName:      subName,
 		server:       server,
 		sendInterval: sendInterval,
-		interval:     interval,
-		metrics:      metrics,
+		control: agentControl{
+			socket: control,
+		},
+		interval: interval,
+		logTags:  logTags,
+		metrics:  metrics,
 		program: execProgram{
-			pid:                 pid,
-			exec:                exec,
-			execArguments:       execArguments,
+			followChildren:      followChildren,
+			execCwd:             execCwd,
+			execEnv:             execEnv,
+			execEnvClear:        execEnvClear,
+			execStdin:           execStdin,
 			limitMemory:         limitMemory,
 			limitMemoryInterval: limitMemoryInterval,
 			limitTime:           limitTime,
+			limitTimeNotice:     limitTimeNotice,
+			stopNotifyGrace:     stopNotifyGrace,
+			stopSignal:          stopSignal,
+			stopGrace:           stopGrace,
 		},
 	}
+
+	for _, p := range programs {
+		a.processes = append(a.processes, newAgentProcess(a, p))
+	}
+
+	return a
 }
 
 // Close uninitializes the agent by closing all connections and programs of the agent.
 func (a *Agent) Close() {
-	a.closeProgram()
-	a.closeSocket()
+	a.closeControl()
+
+	for _, p := range a.processes {
+		p.closeProgram("agent stops")
+		p.closeSocket()
+	}
 }
 
 func (a *Agent) closeServerConn() {
@@ -101,100 +172,478 @@ func (a *Agent) closeServerConn() {
 	}
 }
 
-func (a *Agent) closeProgram() {
-	if a.cmd != nil {
-		if a.cmd.ProcessState == nil {
-			a.V("Program still running. Let's kill it.")
+func (a *Agent) initSigHandler() {
+	a.V("Create signal handler")
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for s := range sig {
+			a.V("Catched signal %v", s)
+
+			switch s {
+			case syscall.SIGUSR1:
+				a.Checkpoint("")
+			case syscall.SIGUSR2:
+				if a.isPaused() {
+					a.Resume()
+				} else {
+					a.Pause()
+				}
+			default:
+				a.Stop(fmt.Sprintf("signal %v", s))
+			}
+		}
+	}()
+}
+
+// Checkpoint requests all clients to dump their state
+func (a *Agent) Checkpoint(name string) {
+	a.command(commandCheckpoint, name, Tag{Tag: strings.TrimSpace("checkpoint " + name), Category: TagCategoryCheckpoint})
+}
 
-			// Kill the program's process group if there is one
-			syscall.Kill(-1*int(a.program.pid), syscall.SIGKILL)
-			// Kill the program via its pid if it does not use its own process group id
-			syscall.Kill(int(a.program.pid), syscall.SIGKILL)
+// Pause requests all clients to pause their workload
+func (a *Agent) Pause() {
+	a.settingsLock.Lock()
+	a.paused = true
+	a.settingsLock.Unlock()
 
-			a.V("Wait for program to close")
+	a.command(commandPause, "", Tag{Tag: "pause"})
+}
 
-			a.cmd.Wait()
-		} else {
-			a.V("Program already terminated")
-		}
+// Resume requests all clients to resume their workload
+func (a *Agent) Resume() {
+	a.settingsLock.Lock()
+	a.paused = false
+	a.settingsLock.Unlock()
+
+	a.command(commandResume, "", Tag{Tag: "resume"})
+}
+
+// isPaused returns if the clients were requested to pause
+func (a *Agent) isPaused() bool {
+	a.settingsLock.RLock()
+	defer a.settingsLock.RUnlock()
+
+	return a.paused
+}
 
-		a.cmd = nil
+// currentInterval returns the interval of fetching metrics which can be changed by the control socket
+func (a *Agent) currentInterval() time.Duration {
+	a.settingsLock.RLock()
+	defer a.settingsLock.RUnlock()
+
+	return time.Duration(a.interval) * time.Millisecond
+}
+
+// RequestStop requests all clients to stop gracefully
+func (a *Agent) RequestStop(reason string) {
+	a.command(commandStop, reason, Tag{Tag: "stop requested: " + reason})
+}
+
+// Stop requests all clients to stop gracefully and stops the run
+func (a *Agent) Stop(reason string) {
+	a.RequestStop(reason)
+
+	a.Running = false
+}
+
+// command sends a command to all clients which support commands and tags the command
+func (a *Agent) command(com byte, argument string, tag Tag) {
+	for _, p := range a.processes {
+		p.command(com, argument)
 	}
+
+	tag.Time = time.Now()
+
+	a.sendMessage(newMessageTag(tag))
 }
 
-func (a *Agent) closeSocket() {
-	if a.fd != nil {
-		a.fd.Close()
+// newMessageTag converts a tag into a tag message
+func newMessageTag(tag Tag) MessageTag {
+	return MessageTag{
+		Message:    Message{tag.Time},
+		Tag:        PrepareTag(tag.Tag),
+		Category:   tag.Category,
+		Severity:   tag.Severity,
+		Attributes: tag.Attributes,
+		Duration:   tag.Duration,
 	}
-	if a.l != nil {
-		a.l.Close()
+}
+
+// sendMessage queues a message for handleMessages. Messages are dropped after the messages of the run are closed.
+func (a *Agent) sendMessage(m interface{}) {
+	a.chMessagesLock.RLock()
+	defer a.chMessagesLock.RUnlock()
+
+	if a.chMessages != nil && !a.chMessagesClosed {
+		a.chMessages <- m
 	}
 }
 
+func (a *Agent) closeMessages() {
+	a.chMessagesLock.Lock()
+	defer a.chMessagesLock.Unlock()
+
+	a.chMessagesClosed = true
+
+	close(a.chMessages)
+}
+
 // Init initializes the agent
 func (a *Agent) Init() {
 	var err error
 
 	a.initSigHandler()
 
-	if err := CheckMetrics(a.metrics); err != nil {
+	if err := a.checkPrograms(); err != nil {
 		a.sPanic(err.Error())
 	}
 
-	a.metricsExternalAll = make(map[int32]int32)
-	a.metricsExternalIO = make(map[int32]int32)
-	a.metricsExternalStat = make(map[int32]int32)
-	a.metricsExternalStatm = make(map[int32]int32)
+	a.expandMetrics()
+
+	// metrics can be declared by the clients during the handshake, so we can only demand metrics if there is no socket
+	if len(a.metrics) != 0 || !a.hasSockets() {
+		if err := CheckMetrics(a.metrics); err != nil {
+			a.sPanic(err.Error())
+		}
+
+		a.initMetrics()
+	}
+
+	a.chMessages = make(chan interface{}, 100)
+
+	if a.server != "" {
+		a.V("Open server connection to %s", a.server)
+
+		// TODO there is a bug in go 1.1.2. if net.Dial is fed an unexisting address, it will uncatchable panic. so we have to work around that
+		a.serverConn, err = net.Dial("tcp", a.server)
+
+		if err != nil {
+			a.sPanic(fmt.Sprintf("Cannot connect to server: %v", err))
+		}
+
+		a.serverClient = httputil.NewClientConn(a.serverConn, nil)
+	}
+
+	for _, p := range a.processes {
+		p.init()
+	}
+
+	a.initControl()
+}
+
+// checkPrograms validates the programs of the agent. Several programs need distinct names to namespace their metrics.
+func (a *Agent) checkPrograms() error {
+	if len(a.processes) == 0 {
+		return fmt.Errorf("no program defined")
+	}
+
+	var programNameRegex = regexp.MustCompile("^[a-zA-Z0-9._-]+$")
+	var names = make(map[string]bool)
+
+	for i, p := range a.processes {
+		if p.name == "" {
+			if len(a.processes) > 1 {
+				return fmt.Errorf("no name defined for program[%d]", i)
+			}
+
+			continue
+		} else if !programNameRegex.MatchString(p.name) {
+			return fmt.Errorf("name of program[%d] uses illegal characters. Only a-z, A-Z, 0-9, ., - and _ are allowed", i)
+		} else if names[p.name] {
+			return fmt.Errorf("name \"%s\" of program[%d] already used", p.name, i)
+		}
+
+		names[p.name] = true
+	}
+
+	return nil
+}
+
+// hasSockets states if at least one program can have a client. Executed programs always get a unix socket.
+func (a *Agent) hasSockets() bool {
+	for _, p := range a.processes {
+		if p.socket != "" || p.program.exec != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSystemMetric states if a metric describes the whole machine instead of a program
+func isSystemMetric(name string) bool {
+	return strings.HasPrefix(name, "sys.")
+}
+
+// metricProcess returns the program of a metric and the name of the metric without the namespace of the program.
+// If the agent monitors one program without a name, every metric belongs to it. System metrics without a namespace are fetched by the first program.
+// Otherwise nil is returned for metrics without a known namespace.
+func (a *Agent) metricProcess(name string) (*agentProcess, string) {
+	if (len(a.processes) == 1 && a.processes[0].name == "") || isSystemMetric(name) {
+		return a.processes[0], name
+	}
+
+	if i := strings.Index(name, "/"); i != -1 {
+		for _, p := range a.processes {
+			if p.name == name[:i] {
+				return p, name[i+1:]
+			}
+		}
+	}
+
+	return nil, name
+}
+
+// isExternalMetric states if a metric is fetched by the agent itself
+func (a *Agent) isExternalMetric(name string) bool {
+	_, n := a.metricProcess(name)
+	_, ok := lookupMetricSource(n)
+
+	return ok
+}
+
+// expandMetrics replaces every external metric without the namespace of a program with the metric of every program.
+// System metrics are the same for every program and are therefore kept once.
+func (a *Agent) expandMetrics() {
+	if a.processes[0].name == "" {
+		return
+	}
+
+	var metrics []Metric
+
+	for _, m := range a.metrics {
+		if _, ok := lookupMetricSource(m.Name); ok && m.Expression == "" && !strings.Contains(m.Name, "/") && !isSystemMetric(m.Name) {
+			for _, p := range a.processes {
+				var pm = m
+
+				pm.Name = p.metricName(m.Name)
+
+				metrics = append(metrics, pm)
+			}
+		} else {
+			metrics = append(metrics, m)
+		}
+	}
+
+	a.metrics = metrics
+}
+
+func (a *Agent) initMetrics() {
+	var indizes = make(map[string]int32)
+
+	for i, m := range a.metrics {
+		indizes[m.Name] = int32(i)
+	}
+
+	a.metricsDerived = nil
+	a.metricsExternal = nil
+
+	for _, p := range a.processes {
+		p.metricsInternal = nil
+		p.metricsSources = nil
+	}
+
+	var sources = make(map[*agentProcess]map[*metricSourceFactory]int)
 
 	for i, m := range a.metrics {
-		if strings.HasPrefix(m.Name, "proc") {
+		if m.Expression != "" {
+			a.V("Derived metric %+v", m)
+
+			e, err := ParseExpression(m.Expression)
+
+			if err == nil {
+				err = e.Bind(indizes)
+			}
+			if err != nil {
+				a.sPanic(fmt.Sprintf("Expression of metric \"%s\": %v", m.Name, err))
+			}
+
+			a.metricsDerived = append(a.metricsDerived, derivedMetric{int32(i), e})
+
+			continue
+		}
+
+		p, n := a.metricProcess(m.Name)
+
+		if p == nil {
+			a.sPanic(fmt.Sprintf("Metric \"%s\" does not belong to a program. Prefix its name with the name of a program e.g. \"%s\"", m.Name, a.processes[0].metricName(m.Name)))
+		}
+
+		if f, ok := lookupMetricSource(n); ok {
+			if m.Aggregate != "" {
+				a.sPanic(fmt.Sprintf("External metric \"%s\" cannot be aggregated over clients", m.Name))
+			}
+
+			if an, ok := proc.Annotations[n]; ok {
+				if m.Unit == "" && m.Scale == 0.0 {
+					m.Unit = an.Unit
+					m.Scale = an.Scale
+				}
+				if m.Description == "" {
+					m.Description = an.Description
+				}
+
+				a.metrics[i] = m
+			}
+
 			a.V("External metric %+v", m)
 
 			a.metricsExternal = append(a.metricsExternal, int32(i))
 
-			if k, ok := proc.AllIndizes[m.Name]; ok {
-				a.metricsExternalAll[int32(k)] = int32(i)
-			} else if k, ok := proc.IOIndizes[m.Name]; ok {
-				a.metricsExternalIO[int32(k)] = int32(i)
-			} else if k, ok := proc.StatIndizes[m.Name]; ok {
-				a.metricsExternalStat[int32(k)] = int32(i)
-			} else if k, ok := proc.StatmIndizes[m.Name]; ok {
-				a.metricsExternalStatm[int32(k)] = int32(i)
-			} else {
+			if f.names != nil && !f.names[n] {
 				a.sPanic(fmt.Sprintf("Unknown metric \"%s\"", m.Name))
 			}
+
+			if sources[p] == nil {
+				sources[p] = make(map[*metricSourceFactory]int)
+			}
+
+			k, ok := sources[p][f]
+
+			if !ok {
+				k = len(p.metricsSources)
+				sources[p][f] = k
+
+				p.metricsSources = append(p.metricsSources, agentSource{source: f.new()})
+			}
+
+			p.metricsSources[k].metrics = append(p.metricsSources[k].metrics, int32(i))
+
+			if f.prototype.Series() {
+				a.metrics[i].Series = true
+			}
+		} else if isSourceNamespace(n) {
+			a.sPanic(fmt.Sprintf("Unknown metric \"%s\"", m.Name))
 		} else {
+			// every client is recorded as its own series
+			if m.Aggregate == AggregateWorker {
+				a.metrics[i].Series = true
+			}
+
 			a.V("Internal metric %+v", m)
 
-			a.metricsInternal = append(a.metricsInternal, int32(i))
+			p.metricsInternal = append(p.metricsInternal, int32(i))
 		}
 	}
+}
 
-	a.chMessages = make(chan interface{}, 100)
+// mergeMetrics replaces the internal metrics of a program with the metrics declared by its client.
+// External metrics and the internal metrics of other programs are kept. Optional fields which the client does not declare are taken from the metric file.
+func (a *Agent) mergeMetrics(p *agentProcess, declared []Metric) error {
+	if err := CheckMetrics(declared); err != nil {
+		return fmt.Errorf("declared metrics: %v", err)
+	}
 
-	if a.server != "" {
-		a.V("Open server connection to %s", a.server)
+	var declaredNames = make(map[string]int)
 
-		// TODO there is a bug in go 1.1.2. if net.Dial is fed an unexisting address, it will uncatchable panic. so we have to work around that
-		a.serverConn, err = net.Dial("tcp", a.server)
+	for i, m := range declared {
+		if _, ok := lookupMetricSource(m.Name); ok || isSourceNamespace(m.Name) {
+			return fmt.Errorf("declared metric[%d] \"%s\" is an external metric", i, m.Name)
+		} else if m.Expression != "" {
+			return fmt.Errorf("declared metric[%d] \"%s\" must not be a derived metric", i, m.Name)
+		}
 
-		if err != nil {
-			a.sPanic(fmt.Sprintf("Cannot connect to server: %v", err))
+		declared[i].Name = p.metricName(m.Name)
+
+		declaredNames[declared[i].Name] = i
+	}
+
+	var metrics []Metric
+
+	for _, m := range a.metrics {
+		if owner, _ := a.metricProcess(m.Name); a.isExternalMetric(m.Name) || m.Expression != "" || owner != p {
+			metrics = append(metrics, m)
+		} else if i, ok := declaredNames[m.Name]; !ok {
+			a.V("Internal metric \"%s\" of the metric file is not declared by the client", m.Name)
+		} else if d := &declared[i]; d.Type != m.Type {
+			return fmt.Errorf("declared metric \"%s\" has type \"%s\" but the metric file defines type \"%s\"", m.Name, d.Type, m.Type)
+		} else {
+			if d.Kind == "" {
+				d.Kind = m.Kind
+			}
+			if d.Unit == "" && d.Scale == 0.0 {
+				d.Unit = m.Unit
+				d.Scale = m.Scale
+			}
+			if d.Description == "" {
+				d.Description = m.Description
+			}
+			if d.Aggregate == "" {
+				d.Aggregate = m.Aggregate
+			}
 		}
+	}
 
-		a.serverClient = httputil.NewClientConn(a.serverConn, nil)
+	a.metrics = append(metrics, declared...)
+
+	return CheckMetrics(a.metrics)
+}
+
+// addMetrics adds the metrics declared by a client of a program without internal metrics to the started run.
+// The declared metrics are appended, so the indices of the other metrics stay valid. The caller must hold metricsLock.
+func (a *Agent) addMetrics(p *agentProcess, declared []Metric) error {
+	var metrics = a.metrics
+
+	if err := a.mergeMetrics(p, declared); err != nil {
+		a.metrics = metrics
+
+		return err
+	}
+
+	for i := len(metrics); i < len(a.metrics); i++ {
+		// every client is recorded as its own series
+		if a.metrics[i].Aggregate == AggregateWorker {
+			a.metrics[i].Series = true
+		}
+
+		a.V("Internal metric %+v", a.metrics[i])
+
+		p.metricsInternal = append(p.metricsInternal, int32(i))
+	}
+
+	var added = make([]Metric, len(a.metrics)-len(metrics))
 
+	copy(added, a.metrics[len(metrics):])
+
+	a.sendMessage(MessageMetrics{Message{time.Now()}, added})
+
+	return nil
+}
+
+func (a *Agent) startRun() {
+	if a.serverClient != nil {
 		a.V("Request new run ID")
 
 		m, _ := json.Marshal(a.metrics)
 
+		// a run records the execution of one program, so the first executed program is recorded with the run and further executed programs are tagged
+		var program = a.program
+		var recorded bool
+
+		for _, p := range a.processes {
+			if p.program.exec == "" {
+				continue
+			} else if !recorded {
+				program = p.program
+				recorded = true
+			} else {
+				p.tag(time.Now(), "executed "+QuoteArguments(append([]string{p.program.exec}, p.program.execArguments...)))
+			}
+		}
+
 		runRequestData := url.Values{
 			"name":           []string{a.name},
 			"sub_name":       []string{a.subName},
-			"interval":       []string{strconv.FormatInt(int64(a.interval), 10)},
+			"interval":       []string{strconv.FormatInt(int64(a.currentInterval()/time.Millisecond), 10)},
 			"metrics":        []string{string(m)},
-			"prog":           []string{a.program.exec},
-			"prog_arguments": a.program.execArguments,
+			"prog":           []string{program.exec},
+			"prog_arguments": []string{QuoteArguments(program.execArguments)},
+			"prog_env":       []string{QuoteArguments(program.execEnv)},
+			"prog_env_clear": []string{strconv.FormatBool(program.execEnvClear)},
+			"prog_cwd":       []string{absolutePath(program.execCwd)},
+			"prog_stdin":     []string{absolutePath(program.execStdin)},
 		}
 		runRequest, err := http.NewRequest("POST", "/program/"+a.name+"/run/start", ioutil.NopCloser(strings.NewReader(runRequestData.Encode())))
 		var runRequestResult MessageReturnStart
@@ -235,72 +684,37 @@ func (a *Agent) Init() {
 		a.writerCSV.Comma = ';'
 		a.writerCSV.Write(append([]string{"time", "tag"}, tagNames...))
 	}
-
-	if a.socket != "" {
-		os.Remove(a.socket)
-
-		var err error
-
-		a.V("Open unix socket to %s", a.socket)
-		a.l, err = net.Listen("unix", a.socket)
-
-		if err != nil {
-			a.sPanic(fmt.Sprintf("Listen to unix socket: %v", err))
-		}
-	}
-
-	if a.program.exec != "" {
-		a.V("Execute external program: %s %s", a.program.exec, strings.Join(a.program.execArguments, " "))
-		a.cmd = exec.Command(a.program.exec, a.program.execArguments...)
-
-		a.cmd.Stderr = os.Stderr
-		a.cmd.Stdout = os.Stdout
-	} else if _, err := os.Stat(fmt.Sprintf("/proc/%d/", a.program.pid)); os.IsNotExist(err) {
-		a.sPanic(fmt.Sprintf("PID %d does not exists", a.program.pid))
-	}
 }
 
-func (a *Agent) handleCommands(c chan<- bool) {
-	a.V("Start listening to commands")
-
-	for a.Running {
-		data, err := a.receive()
-
-		switch err {
-		case nil:
-			com := data[0]
-
-			switch com {
-			case 't':
-				a.chMessages <- MessageTag{Message{time.Now()}, PrepareTag(data)[1:]}
-			default:
-				a.E("Unknown command '%c'", com)
-			}
-		case io.EOF:
-			a.V("Unix socket got closed with EOF")
-
-			a.Running = false
-		default:
-			if strings.HasSuffix(err.Error(), "use of closed network connection") {
-				a.V("Unix socket suddenly got closed")
+// absolutePath returns the absolute form of a path to make it independent of the agent's working directory
+func absolutePath(path string) string {
+	if path == "" {
+		return ""
+	}
 
-				a.Running = false
-			} else {
-				a.sPanic(err)
-			}
-		}
+	abs, err := filepath.Abs(path)
 
+	if err != nil {
+		return path
 	}
 
-	a.V("Stop listening to commands")
-
-	c <- true
+	return abs
 }
 
 func (a *Agent) handleMessages(c chan<- bool) {
 	a.V("Start handling messages")
 
+	a.metricsLock.RLock()
+
 	var currentMetrics = make([]string, len(a.metrics))
+	// the CSV header is written again with these columns if metrics are added to the run
+	var columns = []string{"time", "tag"}
+
+	for _, m := range a.metrics {
+		columns = append(columns, m.Name)
+	}
+
+	a.metricsLock.RUnlock()
 
 	var metrics []MessageData
 	var metricsQueue chan MessageData
@@ -313,6 +727,31 @@ func (a *Agent) handleMessages(c chan<- bool) {
 	var metricsRequestData url.Values
 	var metricsRequestResult MessageReturnInsert
 
+	var metricsAddRequest *http.Request
+	var metricsAddRequestData url.Values
+	var metricsAddRequestResult MessageReturnInsert
+
+	var series []MessageSeries
+	var seriesQueue chan MessageSeries
+
+	var seriesRequest *http.Request
+	var seriesRequestData url.Values
+	var seriesRequestResult MessageReturnInsert
+
+	var logs []MessageLog
+	var logsQueue chan MessageLog
+
+	var spans []MessageSpan
+	var spansQueue chan MessageSpan
+
+	var spansRequest *http.Request
+	var spansRequestData url.Values
+	var spansRequestResult MessageReturnInsert
+
+	var logsRequest *http.Request
+	var logsRequestData url.Values
+	var logsRequestResult MessageReturnInsert
+
 	var sendMetrics func()
 	var sendMetricsTicker *time.Ticker
 
@@ -320,7 +759,7 @@ func (a *Agent) handleMessages(c chan<- bool) {
 		var err error
 
 		tagRequest, err = http.NewRequest("POST", fmt.Sprintf("/program/%s/run/%d/tag", a.name, a.run), nil)
-		tagRequestData = url.Values{"tag": nil, "time": nil}
+		tagRequestData = url.Values{"tag": nil, "time": nil, "category": nil, "severity": nil, "attributes": nil, "duration": nil}
 
 		if err != nil {
 			a.sPanic(fmt.Sprintf("Cannot create tag request %v", err))
@@ -340,7 +779,172 @@ func (a *Agent) handleMessages(c chan<- bool) {
 
 		metricsRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+		metricsAddRequest, err = http.NewRequest("POST", fmt.Sprintf("/program/%s/run/%d/metrics", a.name, a.run), nil)
+		metricsAddRequestData = url.Values{"metrics": nil}
+
+		if err != nil {
+			a.sPanic(fmt.Sprintf("Cannot create add metrics request %v", err))
+		}
+
+		metricsAddRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		series = make([]MessageSeries, 0, 100)
+		seriesQueue = make(chan MessageSeries, 1000)
+
+		seriesRequest, err = http.NewRequest("POST", fmt.Sprintf("/program/%s/run/%d/series", a.name, a.run), nil)
+		seriesRequestData = url.Values{"series": nil}
+
+		if err != nil {
+			a.sPanic(fmt.Sprintf("Cannot create series request %v", err))
+		}
+
+		seriesRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		sendSeries := func() {
+			count := len(seriesQueue)
+
+			if count == 0 {
+				return
+			}
+
+			series = series[:0]
+
+			for i := 0; i < count; i++ {
+				series = append(series, <-seriesQueue)
+			}
+
+			a.D("Send series to server: %v", series)
+
+			j, _ := json.Marshal(series)
+			seriesRequestData.Set("series", string(j))
+
+			seriesRequest.Body = ioutil.NopCloser(strings.NewReader(seriesRequestData.Encode()))
+
+			resp, err := a.serverClient.Do(seriesRequest)
+
+			if err != nil {
+				a.sPanic(fmt.Sprintf("Cannot do series request %v", err))
+			} else if resp.StatusCode != 200 {
+				a.sPanic(fmt.Sprintf("Series request failed with status %v", resp.StatusCode))
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			json.Unmarshal(body, &seriesRequestResult)
+
+			if seriesRequestResult.Error != "" {
+				a.sPanic(fmt.Sprintf("Series request failed with error %v", seriesRequestResult.Error))
+			}
+		}
+
+		logs = make([]MessageLog, 0, 100)
+		// programs can print far more lines than metrics are fetched, and a full queue would block the program
+		logsQueue = make(chan MessageLog, 100000)
+
+		logsRequest, err = http.NewRequest("POST", fmt.Sprintf("/program/%s/run/%d/log", a.name, a.run), nil)
+		logsRequestData = url.Values{"logs": nil}
+
+		if err != nil {
+			a.sPanic(fmt.Sprintf("Cannot create log request %v", err))
+		}
+
+		logsRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		sendLogs := func() {
+			count := len(logsQueue)
+
+			if count == 0 {
+				return
+			}
+
+			logs = logs[:0]
+
+			for i := 0; i < count; i++ {
+				logs = append(logs, <-logsQueue)
+			}
+
+			a.D("Send logs to server: %v", logs)
+
+			j, _ := json.Marshal(logs)
+			logsRequestData.Set("logs", string(j))
+
+			logsRequest.Body = ioutil.NopCloser(strings.NewReader(logsRequestData.Encode()))
+
+			resp, err := a.serverClient.Do(logsRequest)
+
+			if err != nil {
+				a.sPanic(fmt.Sprintf("Cannot do log request %v", err))
+			} else if resp.StatusCode != 200 {
+				a.sPanic(fmt.Sprintf("Log request failed with status %v", resp.StatusCode))
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			json.Unmarshal(body, &logsRequestResult)
+
+			if logsRequestResult.Error != "" {
+				a.sPanic(fmt.Sprintf("Log request failed with error %v", logsRequestResult.Error))
+			}
+		}
+
+		spans = make([]MessageSpan, 0, 100)
+		spansQueue = make(chan MessageSpan, 10000)
+
+		spansRequest, err = http.NewRequest("POST", fmt.Sprintf("/program/%s/run/%d/span", a.name, a.run), nil)
+		spansRequestData = url.Values{"spans": nil}
+
+		if err != nil {
+			a.sPanic(fmt.Sprintf("Cannot create span request %v", err))
+		}
+
+		spansRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		sendSpans := func() {
+			count := len(spansQueue)
+
+			if count == 0 {
+				return
+			}
+
+			spans = spans[:0]
+
+			for i := 0; i < count; i++ {
+				spans = append(spans, <-spansQueue)
+			}
+
+			a.D("Send spans to server: %v", spans)
+
+			j, _ := json.Marshal(spans)
+			spansRequestData.Set("spans", string(j))
+
+			spansRequest.Body = ioutil.NopCloser(strings.NewReader(spansRequestData.Encode()))
+
+			resp, err := a.serverClient.Do(spansRequest)
+
+			if err != nil {
+				a.sPanic(fmt.Sprintf("Cannot do span request %v", err))
+			} else if resp.StatusCode != 200 {
+				a.sPanic(fmt.Sprintf("Span request failed with status %v", resp.StatusCode))
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			json.Unmarshal(body, &spansRequestResult)
+
+			if spansRequestResult.Error != "" {
+				a.sPanic(fmt.Sprintf("Span request failed with error %v", spansRequestResult.Error))
+			}
+		}
+
 		sendMetrics = func() {
+			// series are sent first as they are queued before their metrics
+			sendSeries()
+			sendLogs()
+			sendSpans()
+
 			count := len(metricsQueue)
 
 			if count == 0 {
@@ -398,15 +1002,95 @@ func (a *Agent) handleMessages(c chan<- bool) {
 			} else {
 				metricsQueue <- m
 			}
+		case MessageMetrics:
+			if a.writerCSV != nil {
+				for _, metric := range m.Metrics {
+					columns = append(columns, metric.Name)
+					currentMetrics = append(currentMetrics, "")
+				}
+
+				// the following records have more columns
+				a.writerCSV.Write(columns)
+				a.writerCSV.Flush()
+			} else {
+				// data with the added metrics is queued after this message, so the server knows the metrics before their values
+				a.D("Send added metrics to server %+v", m.Metrics)
+
+				j, _ := json.Marshal(m.Metrics)
+				metricsAddRequestData.Set("metrics", string(j))
+
+				metricsAddRequest.Body = ioutil.NopCloser(strings.NewReader(metricsAddRequestData.Encode()))
+
+				resp, err := a.serverClient.Do(metricsAddRequest)
+
+				if err != nil {
+					a.sPanic(fmt.Sprintf("Cannot do add metrics request %v", err))
+				} else if resp.StatusCode != 200 {
+					a.sPanic(fmt.Sprintf("Add metrics request failed with status %v", resp.StatusCode))
+				}
+
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				json.Unmarshal(body, &metricsAddRequestResult)
+
+				if metricsAddRequestResult.Error != "" {
+					a.sPanic(fmt.Sprintf("Add metrics request failed with error %v", metricsAddRequestResult.Error))
+				}
+			}
+		case MessageSeries:
+			// series are not part of the CSV output as its columns are fixed
+			if a.writerCSV == nil {
+				seriesQueue <- m
+			}
+		case MessageLog:
+			// in CSV mode the output of the program is already forwarded to STDERR
+			if a.writerCSV == nil {
+				logsQueue <- m
+			}
+		case MessageSpan:
+			if a.writerCSV != nil {
+				// spans are written as a start and an end record
+				var t = m.Time
+				var tag = "span start: " + m.Name
+
+				if !m.Stop.IsZero() {
+					t = m.Stop
+					tag = fmt.Sprintf("span end: %s (%s)", m.Name, m.Stop.Sub(m.Time))
+
+					if m.Unfinished {
+						tag += " unfinished"
+					}
+				}
+
+				a.writerCSV.Write(append([]string{strconv.FormatInt(t.UnixNano(), 10), tag}, currentMetrics...))
+				a.writerCSV.Flush()
+			} else if !m.Stop.IsZero() {
+				// the server records only finished spans
+				spansQueue <- m
+			}
 		case MessageTag:
 			if a.writerCSV != nil {
-				a.writerCSV.Write(append([]string{strconv.FormatInt(m.Time.UnixNano(), 10), m.Tag}, currentMetrics...))
+				var tag = Tag{Tag: m.Tag, Category: m.Category, Severity: m.Severity, Attributes: m.Attributes, Duration: m.Duration}
+
+				a.writerCSV.Write(append([]string{strconv.FormatInt(m.Time.UnixNano(), 10), tag.String()}, currentMetrics...))
 				a.writerCSV.Flush()
 			} else {
 				a.D("Send tag to server %+v", m)
 
 				tagRequestData.Set("tag", m.Tag)
 				tagRequestData.Set("time", strconv.FormatInt(m.Time.UnixNano(), 10))
+				tagRequestData.Set("category", m.Category)
+				tagRequestData.Set("severity", m.Severity)
+				tagRequestData.Set("duration", strconv.FormatInt(int64(m.Duration), 10))
+
+				if len(m.Attributes) != 0 {
+					attributes, _ := json.Marshal(m.Attributes)
+
+					tagRequestData.Set("attributes", string(attributes))
+				} else {
+					tagRequestData.Set("attributes", "")
+				}
 
 				tagRequest.Body = ioutil.NopCloser(strings.NewReader(tagRequestData.Encode()))
 
@@ -441,258 +1125,176 @@ func (a *Agent) handleMessages(c chan<- bool) {
 }
 
 func (a *Agent) handleMetrics(c chan<- bool) {
-	pidFolder := fmt.Sprintf("/proc/%d/", a.program.pid)
-
-	a.V("Start fetching metrics")
+	for _, p := range a.processes {
+		p.openSources()
+		defer p.closeSources()
 
-	for a.Running {
-		if _, err := os.Stat(pidFolder); os.IsNotExist(err) || (a.cmd != nil && a.cmd.ProcessState != nil) {
-			a.V("PID disappeared")
+		// the output of the program is captured since its start
+		p.openLogs()
+	}
 
-			a.Running = false
+	// descendants of every program of the last fetch which can be followed if the program disappears
+	var descendants = make([][]int, len(a.processes))
 
-			break
-		}
+	a.V("Start fetching metrics")
 
-		// NOTE: we have to create this metrics slice everytime because otherwise it would be just a pointer :-)
-		var metrics = make([]float32, len(a.metrics))
-		var now = time.Now()
+	for a.Running {
+		for i, p := range a.processes {
+			if p.programDisappeared() {
+				if !p.followProgram(descendants[i]) {
+					p.V("PID disappeared")
 
-		if len(a.metricsExternalAll) > 0 {
-			pAll, err := proc.ReadAllArray(int(a.program.pid))
+					// the programs of a run belong together, so the run stops if one of them is gone
+					a.Running = false
 
-			if err != nil {
-				a.E("read all: " + err.Error())
+					break
+				}
 
-				break
+				descendants[i] = nil
 			}
 
-			for k, v := range a.metricsExternalAll {
-				f, _ := strconv.ParseFloat(pAll[k], 32)
-				metrics[v] = float32(f)
+			if p.program.followChildren == FollowChild {
+				descendants[i] = readDescendants(p.program.pid)
 			}
 		}
 
-		if len(a.metricsExternalIO) > 0 {
-			pIO, err := proc.ReadIOArray(pidFolder + "io")
+		if !a.Running {
+			break
+		}
 
-			if err != nil {
-				a.E("read io: " + err.Error())
+		// clients can add metrics while the metrics are fetched
+		a.metricsLock.RLock()
 
-				break
-			}
+		// NOTE: we have to create this metrics slice everytime because otherwise it would be just a pointer :-)
+		var metrics = make([]float32, len(a.metrics))
+		var now = time.Now()
 
-			for k, v := range a.metricsExternalIO {
-				f, _ := strconv.ParseFloat(pIO[k], 32)
-				metrics[v] = float32(f)
-			}
-		}
+		var series []SeriesValue
+		var tags []string
 
-		if len(a.metricsExternalStat) > 0 {
-			pStat, err := proc.ReadStatArray(pidFolder + "stat")
+		for _, p := range a.processes {
+			s, t := p.readSources(metrics)
 
-			if err != nil {
-				a.E("read stat: " + err.Error())
+			series = append(series, s...)
 
-				break
-			}
+			for _, tag := range t {
+				if p.name != "" {
+					tag = p.name + ": " + tag
+				}
 
-			for k, v := range a.metricsExternalStat {
-				f, _ := strconv.ParseFloat(pStat[k], 32)
-				metrics[v] = float32(f)
+				tags = append(tags, tag)
 			}
+
+			series = append(series, p.readCollector(metrics)...)
 		}
 
-		if len(a.metricsExternalStatm) > 0 {
-			pStatm, err := proc.ReadStatmArray(pidFolder + "statm")
+		for _, d := range a.metricsDerived {
+			metrics[d.index] = d.expression.Eval(metrics, now)
+		}
 
-			if err != nil {
-				a.E("read statm: " + err.Error())
+		a.setCurrentMetrics(now, metrics)
 
-				break
-			}
+		a.metricsLock.RUnlock()
 
-			for k, v := range a.metricsExternalStatm {
-				f, _ := strconv.ParseFloat(pStatm[k], 32)
-				metrics[v] = float32(f)
-			}
+		// all events of one fetch are combined into one tag
+		if len(tags) > 0 {
+			a.chMessages <- MessageTag{Message: Message{now}, Tag: PrepareTag(strings.Join(tags, ", "))}
 		}
 
-		if a.metricsCollector != nil {
-			for i, v := range a.metricsCollector.Data() {
-				metrics[a.metricsInternal[i]] = v
-			}
+		if len(series) > 0 {
+			a.chMessages <- MessageSeries{Message{now}, series}
 		}
 
 		a.chMessages <- MessageData{Message{now}, metrics}
 
-		time.Sleep(time.Duration(a.interval) * time.Millisecond)
+		time.Sleep(a.currentInterval())
 	}
 
 	a.V("Stop fetching metrics")
 
-	a.closeProgram()
+	for _, p := range a.processes {
+		p.closeProgram("agent stops")
+	}
 
 	c <- true
 }
 
 // Run starts all communication and programs of the agent.
 func (a *Agent) Run() {
-	var err error
-
 	a.Running = true
 
-	if a.cmd != nil {
-		err := a.cmd.Start()
-
-		if err != nil {
-			a.sPanic(err)
+	for _, p := range a.processes {
+		if !p.start() {
+			return
 		}
 
-		// if the program exits on its own we immediately want to know about it
-		go a.cmd.Wait()
-
-		defer a.closeProgram()
-
-		a.program.pid = int32(a.cmd.Process.Pid)
-
-		if a.program.limitTime > 0 {
-			time.AfterFunc(time.Duration(a.program.limitTime)*time.Second, func() {
-				a.V("Limit reached. Program ran for %d seconds.", a.program.limitTime)
-
-				a.closeProgram()
-			})
-		}
-		if a.program.limitMemory > 0 {
-			go func() {
-				t := time.Tick(time.Duration(a.program.limitMemoryInterval) * time.Millisecond)
-
-				for {
-					select {
-					case <-t:
-						var all, err = proc.ReadAll(int(a.program.pid))
-
-						if err != nil {
-							a.E("Cannot fetch memory for memory limit: %v", err)
-
-							a.closeProgram()
-
-							return
-						}
-
-						c := all.RSSize / 1024
-
-						if c > a.program.limitMemory {
-							a.V("Limit reached. Program has %d out of %d allowed MB of memory.", c, a.program.limitMemory)
-
-							a.closeProgram()
-
-							return
-						}
-					}
-				}
-			}()
-		}
+		defer p.closeProgram("agent stops")
 	}
 
-	a.V("Monitor program with PID %d", a.program.pid)
-
-	if a.l != nil {
-		closeUnix := time.AfterFunc(1*time.Second, func() {
-			a.E("Timeout reading unix socket")
-
-			a.l.Close()
-		})
-
-		a.fd, err = a.l.Accept()
-
-		closeUnix.Stop()
-
-		defer a.closeSocket()
-
-		if err != nil {
-			if strings.HasSuffix(err.Error(), "use of closed network connection") {
-				a.E("Unix socket got already closed")
+	for _, p := range a.processes {
+		if p.l != nil {
+			defer p.closeSocket()
 
+			if !p.handshake() {
 				return
 			}
 
-			a.sPanic(fmt.Sprintf("Accept %v", err))
-		}
-
-		clientVersion, err := a.receive()
-
-		if err != nil {
-			a.sPanic(err)
-		}
-
-		var matchClientVersion = regexp.MustCompile("^tirion v([0-9.]+)\t([a-z,]+)$").FindStringSubmatch(clientVersion)
-
-		if matchClientVersion == nil {
-			a.sPanic("Client did not send tirion protocol version")
+			defer p.closeClients(true)
 		}
+	}
 
-		a.V("Requested tirion protocol version v%s", matchClientVersion[1])
-		a.V("Using tirion protocol version v" + Version)
-
-		var metricCount = len(a.metricsInternal)
-		var preferredProtocols = strings.Split(matchClientVersion[2], ",")
-
-		a.V("Preferred metric protocols %v", preferredProtocols)
-
-		for _, v := range preferredProtocols {
-			a.metricsCollector, err = collector.NewCollector(v)
-
-			if err == nil {
-				break
-			}
-		}
-
-		if err != nil {
-			a.sPanic(fmt.Sprintf("Cannot create metric collector: %v", err))
-		}
-
-		colURL, err := a.metricsCollector.InitAgent(a.program.pid, int32(metricCount))
-
-		if err != nil {
-			a.sPanic(fmt.Sprintf("Cannot initialize metric collector: %v", err))
-		}
-
-		a.V("Initialized metric collector %s", colURL.Scheme)
-
-		defer a.metricsCollector.Close()
-
-		a.V("Send metric count %d and metric protocol URL %s", metricCount, colURL.String())
-		if err := a.send(fmt.Sprintf("%d\t%s", metricCount, colURL.String())); err != nil {
-			a.sPanic(fmt.Sprintf("Send error: %v", err))
-		}
+	if len(a.metrics) == 0 {
+		a.sPanic("No metrics defined by the metric file nor declared by a client")
 	}
 
-	var chHandleCommands chan bool
+	a.startRun()
+
 	var chHandleMessages = make(chan bool)
 	var chHandleMetrics = make(chan bool)
 
 	go a.handleMessages(chHandleMessages)
-	if a.l != nil {
-		chHandleCommands = make(chan bool)
+	for _, p := range a.processes {
+		if p.l != nil {
+			for _, c := range p.clients {
+				p.clientHandlers.Add(1)
+
+				go c.handleCommands()
+			}
 
-		go a.handleCommands(chHandleCommands)
+			go p.acceptClients()
+		}
 	}
 	go a.handleMetrics(chHandleMetrics)
 
 	<-chHandleMetrics
-	if a.l != nil {
-		<-chHandleCommands
+	for _, p := range a.processes {
+		// clients of programs which are still running would keep their connection open
+		p.closeClients(!p.programDisappeared())
+	}
+	for _, p := range a.processes {
+		p.closeLogs()
 	}
 
-	close(a.chMessages)
+	a.closeMessages()
 
 	<-chHandleMessages
 
 	if a.serverClient != nil {
 		a.V("Request stop of run")
 
-		stopRequest, err := http.NewRequest("GET", fmt.Sprintf("/program/%s/run/%d/stop", a.name, a.run), nil)
+		var reasons []string
+
+		for _, p := range a.processes {
+			if p.stopStage == "" {
+				continue
+			} else if p.name != "" {
+				reasons = append(reasons, p.name+": "+p.stopStage)
+			} else {
+				reasons = append(reasons, p.stopStage)
+			}
+		}
+
+		stopRequest, err := http.NewRequest("GET", fmt.Sprintf("/program/%s/run/%d/stop?reason=%s", a.name, a.run, url.QueryEscape(strings.Join(reasons, ", "))), nil)
 		var stopRequestResult MessageReturnStop
 
 		if err != nil {
@@ -727,8 +1329,12 @@ func (a *Agent) sPanic(err interface{}) {
 	 * There must be a better solution to this...
 	 */
 
-	if a.metricsCollector != nil {
-		a.metricsCollector.Close()
+	for _, p := range a.processes {
+		for _, c := range p.clients {
+			if c.metricsCollector != nil {
+				c.metricsCollector.Close()
+			}
+		}
 	}
 
 	panic(err)
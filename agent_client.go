@@ -0,0 +1,523 @@
+package tirion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/zimmski/tirion/codec"
+	"github.com/zimmski/tirion/collector"
+	"github.com/zimmski/tirion/proc"
+)
+
+// agentClient contains the state of one client of a monitored program.
+// A program can have several clients e.g. the worker processes of a pre-forking server, every client fills its own metric collector.
+// The embedded Tirion object holds the connection to the client.
+type agentClient struct {
+	Tirion
+	metricsCollector collector.Collector
+	name             string // series name of the client which consists of its PID and comm
+	pid              int32
+	process          *agentProcess
+	spans            map[int64]*MessageSpan // open spans of the client by their IDs
+}
+
+// peerPid returns the PID of the process on the other side of a unix socket connection
+func peerPid(fd net.Conn) (int32, error) {
+	u, ok := fd.(*net.UnixConn)
+
+	if !ok {
+		return 0, fmt.Errorf("not a unix socket connection")
+	}
+
+	raw, err := u.SyscallConn()
+
+	if err != nil {
+		return 0, err
+	}
+
+	var cred *syscall.Ucred
+
+	if err := raw.Control(func(f uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(f), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return cred.Pid, nil
+}
+
+// newClient negotiates the protocol and the metric collector with a connected client.
+// Only the first client of a program which declares metrics defines the internal metrics, further clients must declare the same metrics.
+func (p *agentProcess) newClient(fd net.Conn, first bool) (*agentClient, error) {
+	pid, err := peerPid(fd)
+
+	if err != nil {
+		if !first {
+			return nil, fmt.Errorf("cannot read PID of client: %v", err)
+		}
+
+		pid = p.program.pid
+	}
+
+	var c = &agentClient{
+		Tirion: Tirion{
+			fd:        fd,
+			conn:      codec.NewConn(fd),
+			verbose:   p.verbose,
+			logPrefix: fmt.Sprintf("%s client %d]", strings.TrimSuffix(p.logPrefix, "]"), pid),
+		},
+		name:    fmt.Sprintf("%d", pid),
+		pid:     pid,
+		process: p,
+	}
+
+	if stat, err := proc.ReadStat(pidFolder(pid) + "stat"); err == nil {
+		c.name = fmt.Sprintf("%d (%s)", pid, stat.Comm)
+	}
+
+	clientHello, err := c.conn.ReadHello()
+
+	if err != nil {
+		return nil, err
+	}
+
+	hello, err := codec.ParseHello(clientHello)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.V("Requested tirion protocol version v%s", hello.Version)
+
+	version, err := codec.Negotiate(Version, hello.Version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.capabilities = codec.Intersect(capabilities, hello.Capabilities)
+
+	c.V("Using tirion protocol version v%s with capabilities %v and framed messages %t", version, c.capabilities, c.conn.Framed())
+
+	var declared []Metric
+
+	if hello.Metrics != "" {
+		if err := json.Unmarshal([]byte(hello.Metrics), &declared); err != nil {
+			return nil, fmt.Errorf("cannot parse declared metrics: %v", err)
+		}
+
+		c.V("Client declared metrics %+v", declared)
+	}
+
+	metricNames, err := p.clientMetrics(declared, first)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var metricCount = len(metricNames)
+	var preferredProtocols = hello.Protocols
+
+	c.V("Preferred metric protocols %v", preferredProtocols)
+
+	for _, v := range preferredProtocols {
+		c.metricsCollector, err = collector.NewCollector(v)
+
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot create metric collector: %v", err)
+	}
+
+	// several clients can share a PID e.g. if a process reconnects, so every client gets its own metric collector
+	colURL, err := c.metricsCollector.InitAgent(pid, atomic.AddInt32(&p.agent.clientCount, 1), int32(metricCount))
+
+	if err != nil {
+		c.metricsCollector = nil
+
+		return nil, fmt.Errorf("cannot initialize metric collector: %v", err)
+	}
+
+	c.V("Initialized metric collector %s", colURL.Scheme)
+
+	var reply = codec.Reply{
+		MetricCount:  metricCount,
+		URL:          colURL.String(),
+		MetricNames:  metricNames,
+		Version:      version,
+		Capabilities: c.capabilities,
+	}
+
+	c.V("Send metric count %d, metric protocol URL %s and metric names %v", metricCount, colURL.String(), metricNames)
+	if err := c.send(reply.String()); err != nil {
+		c.metricsCollector.Close()
+
+		return nil, fmt.Errorf("send error: %v", err)
+	}
+
+	return c, nil
+}
+
+// clientMetrics handles the metrics declared by a client and returns the names of the internal metrics of the program.
+// The client only knows the names of its metrics without the namespace of the program.
+func (p *agentProcess) clientMetrics(declared []Metric, first bool) ([]string, error) {
+	p.agent.metricsLock.Lock()
+	defer p.agent.metricsLock.Unlock()
+
+	if declared != nil {
+		if first {
+			if err := p.agent.mergeMetrics(p, declared); err != nil {
+				return nil, err
+			}
+
+			p.agent.initMetrics()
+		} else if len(p.metricsInternal) == 0 {
+			// the first client which declares metrics can connect after the run started e.g. a client which attaches later
+			if err := p.agent.addMetrics(p, declared); err != nil {
+				return nil, err
+			}
+		} else if err := p.checkDeclaredMetrics(declared); err != nil {
+			return nil, err
+		}
+	} else if len(p.agent.metrics) == 0 {
+		return nil, fmt.Errorf("no metrics defined by the metric file nor declared by the client")
+	}
+
+	var metricNames = make([]string, len(p.metricsInternal))
+
+	for i, m := range p.metricsInternal {
+		metricNames[i] = strings.TrimPrefix(p.agent.metrics[m].Name, p.metricName(""))
+	}
+
+	return metricNames, nil
+}
+
+// checkDeclaredMetrics checks that a further client of the program declared the same internal metrics as the first client
+func (p *agentProcess) checkDeclaredMetrics(declared []Metric) error {
+	if len(declared) != len(p.metricsInternal) {
+		return fmt.Errorf("declared %d metrics but the program has %d internal metrics", len(declared), len(p.metricsInternal))
+	}
+
+	for i, m := range p.metricsInternal {
+		var internal = p.agent.metrics[m]
+
+		if p.metricName(declared[i].Name) != internal.Name || declared[i].Type != internal.Type {
+			return fmt.Errorf("declared metric[%d] \"%s\" of type \"%s\" differs from internal metric \"%s\" of type \"%s\"", i, declared[i].Name, declared[i].Type, internal.Name, internal.Type)
+		}
+	}
+
+	return nil
+}
+
+// acceptClients accepts further clients of the program until the clients are closed
+func (p *agentProcess) acceptClients() {
+	p.V("Start accepting clients")
+
+	for {
+		fd, err := p.l.Accept()
+
+		if err != nil {
+			if !strings.HasSuffix(err.Error(), "use of closed network connection") {
+				p.E("Accept client: %v", err)
+			}
+
+			break
+		}
+
+		c, err := p.newClient(fd, false)
+
+		if err != nil {
+			p.E("Cannot accept client: %v", err)
+
+			fd.Close()
+
+			continue
+		}
+
+		if !p.addClient(c) {
+			c.close()
+
+			break
+		}
+
+		p.tag(time.Now(), fmt.Sprintf("client %s connected", c.name))
+	}
+
+	p.V("Stop accepting clients")
+}
+
+// addClient adds a client to the program and starts handling its commands. false is returned if the clients of the program are already closed.
+func (p *agentProcess) addClient(c *agentClient) bool {
+	p.clientsLock.Lock()
+	defer p.clientsLock.Unlock()
+
+	if p.clientsClosed {
+		return false
+	}
+
+	p.clients = append(p.clients, c)
+
+	p.clientHandlers.Add(1)
+
+	go c.handleCommands()
+
+	return true
+}
+
+// removeClient removes a disconnected client from the program. The program is monitored until it exits even if it was its last client.
+func (p *agentProcess) removeClient(c *agentClient) {
+	p.clientsLock.Lock()
+	defer p.clientsLock.Unlock()
+
+	for i, pc := range p.clients {
+		if pc == c {
+			p.clients = append(p.clients[:i], p.clients[i+1:]...)
+
+			break
+		}
+	}
+
+	c.closeSpans()
+	c.close()
+
+	if p.clientsClosed {
+		return
+	}
+
+	if len(p.clients) == 0 {
+		p.V("Last client disconnected")
+	}
+
+	p.tag(time.Now(), fmt.Sprintf("client %s disconnected", c.name))
+}
+
+// closeClients stops accepting clients and closes the connections of all clients if requested.
+// Clients which are not handled yet are closed at once.
+func (p *agentProcess) closeClients(connections bool) {
+	p.clientsLock.Lock()
+
+	p.clientsClosed = true
+
+	if p.l != nil {
+		p.l.Close()
+	}
+
+	if connections {
+		for _, c := range p.clients {
+			c.fd.Close()
+		}
+	}
+
+	p.clientsLock.Unlock()
+
+	p.clientHandlers.Wait()
+
+	p.clientsLock.Lock()
+	defer p.clientsLock.Unlock()
+
+	for _, c := range p.clients {
+		c.closeSpans()
+		c.close()
+	}
+
+	p.clients = nil
+}
+
+// hasCommands states if at least one client of the program supports commands
+func (p *agentProcess) hasCommands() bool {
+	p.clientsLock.RLock()
+	defer p.clientsLock.RUnlock()
+
+	for _, c := range p.clients {
+		if c.hasCapability("command") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// close closes the connection and the metric collector of the client
+func (c *agentClient) close() {
+	if c.fd != nil {
+		c.fd.Close()
+	}
+	if c.metricsCollector != nil {
+		c.metricsCollector.Close()
+
+		c.metricsCollector = nil
+	}
+}
+
+// command sends a command to the client if the client supports commands. false is returned if the command was not sent.
+func (c *agentClient) command(com byte, argument string) bool {
+	if !c.hasCapability("command") {
+		return false
+	}
+
+	c.V("Send command '%c' %s", com, argument)
+
+	if err := c.send(string(com) + PrepareTag(argument)); err != nil {
+		c.E("Cannot send command '%c': %v", com, err)
+
+		return false
+	}
+
+	return true
+}
+
+func (c *agentClient) handleCommands() {
+	var p = c.process
+
+	defer p.clientHandlers.Done()
+
+	c.V("Start listening to commands")
+
+	for p.agent.Running {
+		data, err := c.receive()
+
+		switch err {
+		case nil:
+			if data == "" {
+				c.E("Empty command")
+
+				continue
+			}
+
+			com := data[0]
+
+			switch com {
+			case 't':
+				p.tag(time.Now(), data[1:])
+			case 'S':
+				c.startSpan(data[1:])
+			case 'E':
+				c.endSpan(data[1:])
+			case 'T':
+				var tag Tag
+
+				if err := json.Unmarshal([]byte(data[1:]), &tag); err != nil {
+					c.E("Cannot parse structured tag: %v", err)
+				} else if err := CheckTag(&tag); err != nil {
+					c.E("Structured tag is invalid: %v", err)
+				} else {
+					// the client can date back a tag e.g. the start of a span
+					if tag.Time.IsZero() {
+						tag.Time = time.Now()
+					}
+
+					p.tagStructured(tag)
+				}
+			default:
+				c.E("Unknown command '%c'", com)
+			}
+
+			continue
+		case io.EOF:
+			c.V("Unix socket got closed with EOF")
+		default:
+			if strings.HasSuffix(err.Error(), "use of closed network connection") {
+				c.V("Unix socket suddenly got closed")
+			} else {
+				p.agent.sPanic(err)
+			}
+		}
+
+		break
+	}
+
+	c.V("Stop listening to commands")
+
+	p.removeClient(c)
+}
+
+// startSpan opens a span of the client and records its start
+func (c *agentClient) startSpan(data string) {
+	var m spanMessage
+
+	if err := json.Unmarshal([]byte(data), &m); err != nil || m.Name == "" {
+		c.E("Cannot parse span start %s: %v", data, err)
+
+		return
+	}
+
+	if c.spans == nil {
+		c.spans = make(map[int64]*MessageSpan)
+	}
+
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+
+	var s = &MessageSpan{
+		Message: Message{m.Time},
+		Name:    c.process.metricName(m.Name),
+	}
+
+	if parent, ok := c.spans[m.Parent]; ok {
+		s.Name = parent.Name + "/" + m.Name
+		s.Parent = parent.Name
+	}
+
+	c.spans[m.ID] = s
+
+	c.process.agent.sendMessage(*s)
+}
+
+// endSpan closes a span of the client and records its end
+func (c *agentClient) endSpan(data string) {
+	var m spanMessage
+
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		c.E("Cannot parse span end %s: %v", data, err)
+
+		return
+	}
+
+	s, ok := c.spans[m.ID]
+
+	if !ok {
+		c.E("Span %d was never started", m.ID)
+
+		return
+	}
+
+	delete(c.spans, m.ID)
+
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+
+	s.Stop = m.Time
+
+	c.process.agent.sendMessage(*s)
+}
+
+// closeSpans records the end of all spans which were not ended by the client
+func (c *agentClient) closeSpans() {
+	var now = time.Now()
+
+	for id, s := range c.spans {
+		c.V("Span %s was not ended", s.Name)
+
+		s.Stop = now
+		s.Unfinished = true
+
+		c.process.agent.sendMessage(*s)
+
+		delete(c.spans, id)
+	}
+}
@@ -0,0 +1,342 @@
+package tirion
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zimmski/tirion/codec"
+)
+
+// Commands of the control socket of an agent.
+// A request is one line of the command and its optional argument separated by a tab.
+// The agent answers with one line of "ok" or "error" followed by a tab and the result or the error message.
+const (
+	ControlCheckpoint  = "checkpoint"   // request a checkpoint with the optional name as argument
+	ControlInterval    = "interval"     // change the interval of fetching metrics (in milliseconds)
+	ControlLimitMemory = "limit-memory" // change the memory limit of all executed programs (in MB). "+N" and "-N" change the limit relative to the current one
+	ControlLimitTime   = "limit-time"   // change the time limit of all executed programs (in seconds since the start of the program). "+N" and "-N" change the limit relative to the current one
+	ControlMetrics     = "metrics"      // query the current metric values as JSON object
+	ControlPause       = "pause"        // request the clients to pause
+	ControlResume      = "resume"       // request the clients to resume
+	ControlStop        = "stop"         // request a graceful stop with the optional reason as argument and stop the run
+	ControlTag         = "tag"          // tag the run with the argument which is either the message or a JSON object of a structured Tag
+)
+
+// agentControl contains the state of the control socket of an agent.
+type agentControl struct {
+	current     []float32 // metric values of the last fetch
+	currentLock sync.RWMutex
+	currentTime time.Time
+	l           net.Listener
+	socket      string
+}
+
+// ControlRequest sends a command to the control socket of a running agent and returns the result
+func ControlRequest(socket string, command string, argument string) (string, error) {
+	fd, err := net.Dial("unix", socket)
+
+	if err != nil {
+		return "", err
+	}
+
+	var conn = codec.NewConn(fd)
+	defer conn.Close()
+
+	var request = command
+
+	if argument != "" {
+		request += "\t" + argument
+	}
+
+	if err := conn.WriteMessage(request); err != nil {
+		return "", err
+	}
+
+	reply, err := conn.ReadMessage()
+
+	if err != nil {
+		return "", err
+	}
+
+	var r = strings.SplitN(reply, "\t", 2)
+
+	if len(r) == 1 {
+		r = append(r, "")
+	}
+
+	switch r[0] {
+	case "ok":
+		return r[1], nil
+	case "error":
+		return "", fmt.Errorf("%s", r[1])
+	default:
+		return "", fmt.Errorf("unknown reply \"%s\"", reply)
+	}
+}
+
+// initControl opens the control socket of the agent
+func (a *Agent) initControl() {
+	if a.control.socket == "" {
+		return
+	}
+
+	var err error
+
+	os.Remove(a.control.socket)
+
+	a.V("Open control socket to %s", a.control.socket)
+	a.control.l, err = net.Listen("unix", a.control.socket)
+
+	if err != nil {
+		a.sPanic(fmt.Sprintf("Listen to control socket: %v", err))
+	}
+
+	go a.handleControl()
+}
+
+func (a *Agent) closeControl() {
+	if a.control.l != nil {
+		a.control.l.Close()
+
+		a.control.l = nil
+	}
+}
+
+// setCurrentMetrics remembers the metric values of the last fetch for the control socket
+func (a *Agent) setCurrentMetrics(t time.Time, metrics []float32) {
+	if a.control.socket == "" {
+		return
+	}
+
+	a.control.currentLock.Lock()
+	defer a.control.currentLock.Unlock()
+
+	a.control.current = metrics
+	a.control.currentTime = t
+}
+
+func (a *Agent) handleControl() {
+	a.V("Start listening to the control socket")
+
+	for {
+		fd, err := a.control.l.Accept()
+
+		if err != nil {
+			if !strings.HasSuffix(err.Error(), "use of closed network connection") {
+				a.E("Accept control connection: %v", err)
+			}
+
+			break
+		}
+
+		go a.handleControlConn(codec.NewConn(fd))
+	}
+
+	a.V("Stop listening to the control socket")
+}
+
+func (a *Agent) handleControlConn(conn *codec.Conn) {
+	defer conn.Close()
+
+	for {
+		request, err := conn.ReadMessage()
+
+		if err != nil {
+			if err != io.EOF {
+				a.E("Read control request: %v", err)
+			}
+
+			return
+		}
+
+		var r = strings.SplitN(request, "\t", 2)
+
+		if len(r) == 1 {
+			r = append(r, "")
+		}
+
+		a.V("Control request %s %s", r[0], r[1])
+
+		var reply string
+
+		if result, err := a.controlCommand(r[0], r[1]); err != nil {
+			reply = "error\t" + strings.Replace(err.Error(), "\n", " ", -1)
+		} else {
+			reply = "ok\t" + result
+		}
+
+		if err := conn.WriteMessage(reply); err != nil {
+			a.E("Write control reply: %v", err)
+
+			return
+		}
+	}
+}
+
+// controlCommand executes a command of the control socket
+func (a *Agent) controlCommand(command string, argument string) (string, error) {
+	switch command {
+	case ControlCheckpoint:
+		a.Checkpoint(argument)
+	case ControlInterval:
+		interval, err := strconv.Atoi(argument)
+
+		if err != nil || interval <= 0 {
+			return "", fmt.Errorf("interval must be a positive number")
+		}
+
+		a.settingsLock.Lock()
+		a.interval = int32(interval)
+		a.settingsLock.Unlock()
+
+		a.sendMessage(MessageTag{Message: Message{time.Now()}, Tag: PrepareTag(fmt.Sprintf("interval changed to %dms", interval))})
+
+		return strconv.Itoa(interval), nil
+	case ControlLimitMemory:
+		// concurrent changes of a relative limit must not get lost
+		a.settingsLock.Lock()
+		defer a.settingsLock.Unlock()
+
+		limit, err := parseLimit(a.program.limitMemory, argument)
+
+		if err != nil {
+			return "", err
+		}
+
+		if err := a.setLimit(func(p *agentProcess) { p.setLimitMemory(limit) }); err != nil {
+			return "", err
+		}
+
+		a.program.limitMemory = limit
+
+		a.sendMessage(MessageTag{Message: Message{time.Now()}, Tag: PrepareTag(fmt.Sprintf("memory limit changed to %dMB", limit))})
+
+		return strconv.FormatInt(limit, 10), nil
+	case ControlLimitTime:
+		a.settingsLock.Lock()
+		defer a.settingsLock.Unlock()
+
+		limit, err := parseLimit(int64(a.program.limitTime), argument)
+
+		if err != nil {
+			return "", err
+		}
+
+		if err := a.setLimit(func(p *agentProcess) { p.setLimitTime(int32(limit)) }); err != nil {
+			return "", err
+		}
+
+		a.program.limitTime = int32(limit)
+
+		a.sendMessage(MessageTag{Message: Message{time.Now()}, Tag: PrepareTag(fmt.Sprintf("time limit changed to %ds", limit))})
+
+		return strconv.FormatInt(limit, 10), nil
+	case ControlMetrics:
+		// the metrics are locked first like during the fetch of the metrics
+		a.metricsLock.RLock()
+		defer a.metricsLock.RUnlock()
+
+		a.control.currentLock.RLock()
+		defer a.control.currentLock.RUnlock()
+
+		if a.control.current == nil {
+			return "", fmt.Errorf("no metrics fetched yet")
+		}
+
+		var values = make(map[string]float32, len(a.metrics))
+
+		// metrics which were added after the last fetch have no values yet
+		for i, m := range a.metrics {
+			if i < len(a.control.current) {
+				values[m.Name] = a.control.current[i]
+			}
+		}
+
+		data, err := json.Marshal(values)
+
+		if err != nil {
+			return "", err
+		}
+
+		return string(data), nil
+	case ControlPause:
+		a.Pause()
+	case ControlResume:
+		a.Resume()
+	case ControlStop:
+		if argument == "" {
+			argument = "control"
+		}
+
+		a.Stop(argument)
+	case ControlTag:
+		var tag = Tag{Tag: argument}
+
+		if strings.HasPrefix(argument, "{") {
+			tag = Tag{}
+
+			if err := json.Unmarshal([]byte(argument), &tag); err != nil {
+				return "", fmt.Errorf("cannot parse structured tag: %v", err)
+			}
+		}
+
+		if err := CheckTag(&tag); err != nil {
+			return "", err
+		}
+
+		if tag.Time.IsZero() {
+			tag.Time = time.Now()
+		}
+
+		a.sendMessage(newMessageTag(tag))
+	default:
+		return "", fmt.Errorf("unknown command \"%s\"", command)
+	}
+
+	return "", nil
+}
+
+// setLimit changes a limit of all executed programs
+func (a *Agent) setLimit(set func(p *agentProcess)) error {
+	var executed = false
+
+	for _, p := range a.processes {
+		if p.program.exec != "" {
+			set(p)
+
+			executed = true
+		}
+	}
+
+	if !executed {
+		return fmt.Errorf("limits only work for executed programs")
+	}
+
+	return nil
+}
+
+// parseLimit parses an absolute limit or a limit relative to the current limit given by a leading "+" or "-"
+func parseLimit(current int64, argument string) (int64, error) {
+	v, err := strconv.ParseInt(argument, 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("limit must be a number")
+	}
+
+	if strings.HasPrefix(argument, "+") || strings.HasPrefix(argument, "-") {
+		v += current
+	}
+
+	if v < 0 {
+		return 0, fmt.Errorf("limit must be a positive number")
+	}
+
+	return v, nil
+}
@@ -0,0 +1,857 @@
+package tirion
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zimmski/tirion/proc"
+)
+
+// AgentProgram defines a program which is monitored by an agent.
+// Exactly one of Pid, PidFile, WaitForName and Exec states the process of the program.
+type AgentProgram struct {
+	Name          string         // namespace of the program's metrics e.g. "db" for "db/proc.stat.utime". Only needed if the agent monitors several programs
+	Pid           int32          // PID of an existing process
+	PidFile       string         // wait until this file contains the PID of an existing process
+	WaitForName   *regexp.Regexp // wait until a process with a matching command name exists
+	Exec          string         // execute this command
+	ExecArguments []string       // arguments of the command
+	Socket        string         // unix socket path for the client of the program. A path is generated for executed programs if it is empty
+}
+
+// agentProcess contains the state of one monitored program of an agent.
+// The embedded Tirion object holds the unix socket path for the clients of the program. Its Running state is not used as the process is monitored as long as the agent is running.
+type agentProcess struct {
+	Tirion
+	agent              *Agent
+	clientHandlers     sync.WaitGroup
+	clients            []*agentClient // connected clients of the program
+	clientsClosed      bool           // states if the program does not accept clients anymore
+	clientsLock        sync.RWMutex
+	closed             bool // states if the executed program was already ended by closeProgram. guarded by stopLock
+	cmd                *exec.Cmd
+	exited             chan bool // closed as soon as the executed program exited
+	l                  net.Listener
+	limitLock          sync.Mutex
+	limitMemoryWatched bool // states if the memory limit is checked
+	limitTime          *time.Timer
+	limitTimeNotice    *time.Timer
+	limitTimeNoticed   bool      // states if the client was already requested to stop because of the time limit
+	logLines           []logLine // lines which were captured before the metric sources were opened
+	logLock            sync.Mutex
+	logOpened          bool // states if captured lines are processed right away
+	logReaders         sync.WaitGroup
+	logStreams         []logStream
+	metricsInternal    []int32
+	metricsSources     []agentSource
+	name               string
+	program            execProgram
+	started            time.Time // start of the executed program
+	stopLock           sync.Mutex
+	stopRequested      bool   // states if the clients were already requested to stop
+	stopStage          string // stage which ended the executed program
+}
+
+func newAgentProcess(a *Agent, p AgentProgram) *agentProcess {
+	var logPrefix = "[agent]"
+
+	if p.Name != "" {
+		logPrefix = "[agent " + p.Name + "]"
+	}
+
+	return &agentProcess{
+		Tirion: Tirion{
+			socket:    p.Socket,
+			verbose:   a.verbose,
+			logPrefix: logPrefix,
+		},
+		agent: a,
+		name:  p.Name,
+		program: execProgram{
+			pid:                 p.Pid,
+			pidFile:             p.PidFile,
+			waitForName:         p.WaitForName,
+			followChildren:      a.program.followChildren,
+			exec:                p.Exec,
+			execArguments:       p.ExecArguments,
+			execCwd:             a.program.execCwd,
+			execEnv:             a.program.execEnv,
+			execEnvClear:        a.program.execEnvClear,
+			execStdin:           a.program.execStdin,
+			limitMemory:         a.program.limitMemory,
+			limitMemoryInterval: a.program.limitMemoryInterval,
+			limitTime:           a.program.limitTime,
+			limitTimeNotice:     a.program.limitTimeNotice,
+			stopNotifyGrace:     a.program.stopNotifyGrace,
+			stopSignal:          a.program.stopSignal,
+			stopGrace:           a.program.stopGrace,
+		},
+	}
+}
+
+// metricName returns the name of a metric of the program within the run
+func (p *agentProcess) metricName(name string) string {
+	if p.name == "" {
+		return name
+	}
+
+	return p.name + "/" + name
+}
+
+// tag tags an event of the program. The tag names the program if the agent monitors several programs.
+func (p *agentProcess) tag(t time.Time, tag string) {
+	p.tagStructured(Tag{Time: t, Tag: tag})
+}
+
+// tagStructured tags an event of the program with a structured tag. Tags without a message are skipped as the server rejects them.
+func (p *agentProcess) tagStructured(tag Tag) {
+	if tag.Tag == "" {
+		p.V("Skip tag without message")
+
+		return
+	}
+
+	if p.name != "" {
+		tag.Tag = p.name + ": " + tag.Tag
+	}
+
+	p.agent.sendMessage(newMessageTag(tag))
+}
+
+// command sends a command to all clients of the program which support commands
+func (p *agentProcess) command(com byte, argument string) {
+	p.clientsLock.RLock()
+	defer p.clientsLock.RUnlock()
+
+	for _, c := range p.clients {
+		if c.command(com, argument) && com == commandStop {
+			p.stopRequested = true
+		}
+	}
+}
+
+// closeProgram terminates the executed program if it is still running. The cause is tagged with the stage which ended the program.
+func (p *agentProcess) closeProgram(cause string) {
+	p.stopLock.Lock()
+	defer p.stopLock.Unlock()
+
+	// a program which was never started has no process which could be ended
+	if p.closed || p.cmd == nil || p.exited == nil || p.program.pid <= 0 {
+		return
+	}
+
+	select {
+	case <-p.exited:
+		p.V("Program already terminated")
+
+		// a program which exits on its own after it was requested to stop was ended by the request
+		if p.stopRequested {
+			p.stopStage = StopNotify
+		} else {
+			p.stopStage = StopExited
+		}
+	default:
+		p.stopStage = p.terminate(cause)
+
+		p.tag(time.Now(), fmt.Sprintf("%s: program ended at stage %s", cause, p.stopStage))
+	}
+
+	// cmd stays set as it is read concurrently, exited states that the program ended
+	p.closed = true
+}
+
+// terminate escalates from a stop request to the client over the stop signal to SIGKILL until the program exited.
+// The stage which ended the program is returned.
+func (p *agentProcess) terminate(cause string) string {
+	// waiting for the exit of a program which was never started would block forever
+	if p.exited == nil || p.program.pid <= 0 {
+		return StopExited
+	}
+
+	if p.program.stopNotifyGrace > 0 && p.hasCommands() {
+		if !p.stopRequested {
+			p.command(commandStop, cause)
+		}
+
+		p.V("Wait %d seconds for the program to stop", p.program.stopNotifyGrace)
+
+		if p.waitForExit(p.program.stopNotifyGrace) {
+			return StopNotify
+		}
+	}
+
+	if p.program.stopSignal != syscall.SIGKILL {
+		p.V("Program still running. Let's send %v.", p.program.stopSignal)
+
+		p.signal(p.program.stopSignal)
+
+		if p.waitForExit(p.program.stopGrace) {
+			return StopSignal
+		}
+	}
+
+	p.V("Program still running. Let's kill it.")
+
+	p.signal(syscall.SIGKILL)
+
+	p.V("Wait for program to close")
+
+	<-p.exited
+
+	return StopKill
+}
+
+// signal sends a signal to the program and its process group
+func (p *agentProcess) signal(sig syscall.Signal) {
+	// a PID of 0 would signal the process group of the agent itself
+	if p.program.pid <= 0 {
+		return
+	}
+
+	// Signal the program's process group if there is one
+	syscall.Kill(-1*int(p.program.pid), sig)
+	// Signal the program via its pid if it does not use its own process group id
+	syscall.Kill(int(p.program.pid), sig)
+}
+
+// waitForExit waits the given seconds for the executed program to exit and states if it exited
+func (p *agentProcess) waitForExit(seconds int32) bool {
+	select {
+	case <-p.exited:
+		return true
+	case <-time.After(time.Duration(seconds) * time.Second):
+		return false
+	}
+}
+
+func (p *agentProcess) closeSocket() {
+	if p.l != nil {
+		p.l.Close()
+	}
+}
+
+// init opens the unix socket of the program and prepares its execution
+func (p *agentProcess) init() {
+	var err error
+
+	// executed programs find the unix socket through their environment
+	if p.socket == "" && p.program.exec != "" {
+		var name = fmt.Sprintf("tirion-%d", os.Getpid())
+
+		if p.name != "" {
+			name += "-" + p.name
+		}
+
+		p.socket = filepath.Join(os.TempDir(), name+".sock")
+	}
+
+	if p.socket != "" {
+		os.Remove(p.socket)
+
+		p.V("Open unix socket to %s", p.socket)
+		p.l, err = net.Listen("unix", p.socket)
+
+		if err != nil {
+			p.agent.sPanic(fmt.Sprintf("Listen to unix socket: %v", err))
+		}
+	}
+
+	if p.program.exec != "" {
+		p.V("Execute external program: %s %s", p.program.exec, QuoteArguments(p.program.execArguments))
+		p.cmd = exec.Command(p.program.exec, p.program.execArguments...)
+
+		p.cmd.Dir = p.program.execCwd
+
+		if p.program.execEnvClear {
+			// a nil environment would inherit the environment of the agent
+			p.cmd.Env = append([]string{}, p.program.execEnv...)
+		} else {
+			p.cmd.Env = append(os.Environ(), p.program.execEnv...)
+		}
+
+		if p.socket != "" {
+			p.cmd.Env = append(p.cmd.Env, EnvSocket+"="+p.socket)
+		}
+
+		if p.program.execStdin != "" {
+			p.cmd.Stdin, err = os.Open(p.program.execStdin)
+
+			if err != nil {
+				p.agent.sPanic(fmt.Sprintf("Cannot open STDIN file: %v", err))
+			}
+		}
+
+		// the output of the program is captured line by line by handleLog
+		for _, name := range []string{"stdout", "stderr"} {
+			r, w, err := os.Pipe()
+
+			if err != nil {
+				p.agent.sPanic(fmt.Sprintf("Cannot create %s pipe: %v", name, err))
+			}
+
+			p.logStreams = append(p.logStreams, logStream{name, r, w})
+		}
+
+		p.cmd.Stdout = p.logStreams[0].w
+		p.cmd.Stderr = p.logStreams[1].w
+	} else if p.program.pidFile != "" || p.program.waitForName != nil {
+		// the program is searched in start
+	} else if _, err := os.Stat(pidFolder(p.program.pid)); os.IsNotExist(err) {
+		p.agent.sPanic(fmt.Sprintf("PID %d does not exists", p.program.pid))
+	}
+}
+
+// start executes the program or waits for its process. false is returned if the agent stopped before the process was found.
+func (p *agentProcess) start() bool {
+	if p.cmd != nil {
+		err := p.cmd.Start()
+
+		if err != nil {
+			p.agent.sPanic(err)
+		}
+
+		// if the program exits on its own we immediately want to know about it
+		p.exited = make(chan bool)
+
+		go func() {
+			p.cmd.Wait()
+
+			close(p.exited)
+		}()
+
+		// only the program writes into its output pipes
+		for _, l := range p.logStreams {
+			l.w.Close()
+		}
+		// the output is captured right away as the program blocks if its pipes are full
+		for _, l := range p.logStreams {
+			p.logReaders.Add(1)
+
+			go p.handleLog(l)
+		}
+		if stdin, ok := p.cmd.Stdin.(*os.File); ok {
+			stdin.Close()
+		}
+
+		p.program.pid = int32(p.cmd.Process.Pid)
+
+		p.limitLock.Lock()
+
+		p.started = time.Now()
+
+		p.scheduleLimitTime()
+		if p.program.limitMemory > 0 {
+			p.watchLimitMemory()
+		}
+
+		p.limitLock.Unlock()
+	}
+
+	if p.cmd == nil && p.program.pid <= 0 {
+		if p.program.pidFile != "" {
+			p.V("Wait for PID file %s", p.program.pidFile)
+		} else {
+			p.V("Wait for a process with a name matching %s", p.program.waitForName)
+		}
+
+		p.program.pid = p.waitForProcess(-1)
+
+		if p.program.pid <= 0 {
+			p.V("Stopped waiting for the program")
+
+			return false
+		}
+	}
+
+	p.V("Monitor program with PID %d", p.program.pid)
+
+	return true
+}
+
+// scheduleLimitTime (re)schedules the time limit and its notice relative to the start of the program. The caller must hold limitLock.
+func (p *agentProcess) scheduleLimitTime() {
+	if p.limitTimeNotice != nil && !p.limitTimeNotice.Stop() {
+		// the client was already notified
+		p.limitTimeNoticed = true
+	}
+	if p.limitTime != nil {
+		p.limitTime.Stop()
+	}
+
+	p.limitTime = nil
+	p.limitTimeNotice = nil
+
+	if p.program.limitTime <= 0 {
+		return
+	}
+
+	// the timers get their own copies of the limits as the limits can be changed while they are pending
+	var limit = p.program.limitTime
+	var notice = p.program.limitTimeNotice
+	var end = p.started.Add(time.Duration(limit) * time.Second)
+
+	if !p.limitTimeNoticed && notice > 0 && notice < limit {
+		p.limitTimeNotice = time.AfterFunc(end.Add(-time.Duration(notice)*time.Second).Sub(time.Now()), func() {
+			p.V("Limit will be reached in %d seconds. Request a stop of the program.", notice)
+
+			p.command(commandStop, "time limit")
+
+			p.tag(time.Now(), "stop requested: time limit")
+		})
+	}
+
+	p.limitTime = time.AfterFunc(end.Sub(time.Now()), func() {
+		p.V("Limit reached. Program ran for %d seconds.", limit)
+
+		p.closeProgram("time limit")
+	})
+}
+
+// setLimitTime changes the time limit of the executed program. A limit of 0 removes the limit.
+func (p *agentProcess) setLimitTime(seconds int32) {
+	p.limitLock.Lock()
+	defer p.limitLock.Unlock()
+
+	p.program.limitTime = seconds
+
+	if p.cmd != nil {
+		p.scheduleLimitTime()
+	}
+}
+
+// watchLimitMemory checks the memory limit of the executed program until the program exited. The caller must hold limitLock.
+func (p *agentProcess) watchLimitMemory() {
+	if p.limitMemoryWatched {
+		return
+	}
+
+	p.limitMemoryWatched = true
+
+	go func() {
+		t := time.NewTicker(time.Duration(p.program.limitMemoryInterval) * time.Millisecond)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-p.exited:
+				return
+			case <-t.C:
+				p.limitLock.Lock()
+				var limit = p.program.limitMemory
+				p.limitLock.Unlock()
+
+				if limit <= 0 {
+					continue
+				}
+
+				var all, err = proc.ReadAll(int(p.program.pid))
+
+				if err != nil {
+					p.E("Cannot fetch memory for memory limit: %v", err)
+
+					p.closeProgram("memory limit")
+
+					return
+				}
+
+				c := all.RSSize / 1024
+
+				if c > limit {
+					p.V("Limit reached. Program has %d out of %d allowed MB of memory.", c, limit)
+
+					p.closeProgram("memory limit")
+
+					return
+				}
+			}
+		}
+	}()
+}
+
+// setLimitMemory changes the memory limit of the executed program. A limit of 0 removes the limit.
+func (p *agentProcess) setLimitMemory(mb int64) {
+	p.limitLock.Lock()
+	defer p.limitLock.Unlock()
+
+	p.program.limitMemory = mb
+
+	if p.cmd != nil && mb > 0 {
+		p.watchLimitMemory()
+	}
+}
+
+// handshake accepts the first client of the program and negotiates the metric protocol. false is returned if no client connected.
+// Programs without internal metrics do not need to connect right away, their clients can still connect later e.g. a standalone client which attaches later.
+func (p *agentProcess) handshake() bool {
+	var l = p.l.(*net.UnixListener)
+
+	// the listener stays open after the timeout, so clients can still connect later
+	l.SetDeadline(time.Now().Add(1 * time.Second))
+
+	fd, err := l.Accept()
+
+	l.SetDeadline(time.Time{})
+
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			if len(p.metricsInternal) == 0 {
+				p.V("No client connected to the unix socket yet")
+
+				return true
+			}
+
+			p.E("Timeout reading unix socket")
+
+			return false
+		} else if strings.HasSuffix(err.Error(), "use of closed network connection") {
+			p.E("Unix socket got already closed")
+
+			return false
+		}
+
+		p.agent.sPanic(fmt.Sprintf("Accept %v", err))
+	}
+
+	c, err := p.newClient(fd, true)
+
+	if err != nil {
+		fd.Close()
+
+		p.agent.sPanic(err.Error())
+	}
+
+	p.clients = append(p.clients, c)
+
+	return true
+}
+
+func (p *agentProcess) handleLog(l logStream) {
+	defer p.logReaders.Done()
+
+	p.V("Start capturing %s", l.name)
+
+	// the CSV output is written to STDOUT, so the program's output must not interleave with it
+	var out = os.Stdout
+
+	if l.name != "stdout" || p.agent.serverClient == nil {
+		out = os.Stderr
+	}
+
+	var scanner = bufio.NewScanner(l.r)
+
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var line = logLine{time.Now(), l.name, scanner.Text()}
+
+		fmt.Fprintln(out, line.line)
+
+		p.logLock.Lock()
+
+		if p.logOpened {
+			p.processLine(line)
+		} else {
+			p.logLines = append(p.logLines, line)
+		}
+
+		p.logLock.Unlock()
+	}
+
+	if err := scanner.Err(); err != nil && !strings.HasSuffix(err.Error(), "file already closed") {
+		p.E("Capture %s: %v", l.name, err)
+
+		// the program would block if nobody reads its output
+		io.Copy(ioutil.Discard, l.r)
+	}
+
+	p.V("Stop capturing %s", l.name)
+}
+
+// openLogs processes the lines which were captured before the metric sources were opened and processes further lines right away
+func (p *agentProcess) openLogs() {
+	p.logLock.Lock()
+	defer p.logLock.Unlock()
+
+	p.logOpened = true
+
+	for _, line := range p.logLines {
+		p.processLine(line)
+	}
+
+	p.logLines = nil
+}
+
+// processLine hands a captured line to the log metric sources, tags it if requested and records it. The caller must hold logLock.
+func (p *agentProcess) processLine(line logLine) {
+	for _, s := range p.metricsSources {
+		if r, ok := s.source.(LineReader); ok {
+			r.ReadLine(line.stream, line.line)
+		}
+	}
+
+	for _, re := range p.agent.logTags {
+		if re.MatchString(line.line) {
+			p.tag(line.time, line.line)
+
+			break
+		}
+	}
+
+	// the streams of several programs are distinguished by the namespace of the program
+	p.agent.sendMessage(MessageLog{Message{line.time}, p.metricName(line.stream), line.line})
+}
+
+// closeLogs waits until the output of the program is captured.
+// Processes which inherited the output pipes can keep them open, so the pipes are forcibly closed after a second.
+func (p *agentProcess) closeLogs() {
+	var done = make(chan bool)
+
+	go func() {
+		p.logReaders.Wait()
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		p.V("Output pipes are still open. Let's close them.")
+
+		for _, l := range p.logStreams {
+			l.r.Close()
+		}
+
+		<-done
+	}
+
+	for _, l := range p.logStreams {
+		l.r.Close()
+	}
+}
+
+func (p *agentProcess) openSources() {
+	for _, s := range p.metricsSources {
+		var metrics = make([]Metric, len(s.metrics))
+
+		// clients can already add metrics
+		p.agent.metricsLock.RLock()
+
+		for i, m := range s.metrics {
+			metrics[i] = p.agent.metrics[m]
+			// sources only know the metric names without the namespace of the program
+			metrics[i].Name = strings.TrimPrefix(metrics[i].Name, p.metricName(""))
+		}
+
+		p.agent.metricsLock.RUnlock()
+
+		if err := s.source.Open(p.program.pid, metrics); err != nil {
+			p.E("Open metric source %s: %v", strings.TrimSuffix(s.source.Prefix(), "."), err)
+		}
+	}
+}
+
+func (p *agentProcess) closeSources() {
+	for _, s := range p.metricsSources {
+		s.source.Close()
+	}
+}
+
+// programDisappeared states if the monitored process does not exist anymore
+func (p *agentProcess) programDisappeared() bool {
+	// the process of an executed program exists until it is waited for
+	if p.cmd != nil && int32(p.cmd.Process.Pid) == p.program.pid {
+		select {
+		case <-p.exited:
+			return true
+		default:
+		}
+	}
+
+	return !proc.ProcessExists(int(p.program.pid))
+}
+
+// readDescendants returns the PIDs of all descendants of a process ordered by their distance to the process
+func readDescendants(pid int32) []int {
+	processes, err := proc.ReadProcessTree(int(pid))
+
+	if err != nil {
+		return nil
+	}
+
+	var pids = make([]int, len(processes)-1)
+
+	for i, p := range processes[1:] {
+		pids[i] = p.Tid
+	}
+
+	return pids
+}
+
+// followProgram switches the monitoring to a new process according to the follow policy after the program disappeared
+func (p *agentProcess) followProgram(descendants []int) bool {
+	var pid int32 = -1
+
+	switch p.program.followChildren {
+	case FollowChild:
+		for _, d := range descendants {
+			if proc.ProcessExists(d) {
+				pid = int32(d)
+
+				break
+			}
+		}
+	case FollowWait:
+		p.V("Wait for a new process of the program")
+
+		pid = p.waitForProcess(p.program.pid)
+	}
+
+	if pid <= 0 {
+		return false
+	}
+
+	p.V("Follow PID %d to PID %d", p.program.pid, pid)
+
+	p.tag(time.Now(), fmt.Sprintf("followed process %d to %d", p.program.pid, pid))
+
+	p.program.pid = pid
+
+	p.closeSources()
+	p.openSources()
+
+	return true
+}
+
+// waitForProcess waits until the PID file states an existing process or until a process with a matching name exists.
+// The PID exclude is ignored. -1 is returned if the agent stopped running before a process was found.
+func (p *agentProcess) waitForProcess(exclude int32) int32 {
+	for p.agent.Running {
+		if p.program.pidFile != "" {
+			if pid, err := proc.ReadPidFile(p.program.pidFile); err == nil && int32(pid) != exclude {
+				return int32(pid)
+			}
+		} else {
+			pids, err := proc.FindPidsByName(p.program.waitForName)
+
+			if err != nil {
+				p.E("Search program: %v", err)
+			}
+
+			for _, pid := range pids {
+				if pid != os.Getpid() && int32(pid) != exclude {
+					return int32(pid)
+				}
+			}
+		}
+
+		time.Sleep(p.agent.currentInterval())
+	}
+
+	return -1
+}
+
+// readSources reads the values of all external metrics of the program into the given metrics slice and returns their series and events.
+// A source which cannot be read keeps the values of its last successful read, so one failing source does not stop the monitoring.
+func (p *agentProcess) readSources(metrics []float32) ([]SeriesValue, []string) {
+	var series []SeriesValue
+	var tags []string
+
+	for k := range p.metricsSources {
+		var s = &p.metricsSources[k]
+
+		d, err := s.source.Read()
+
+		if err != nil {
+			// only the first error of a row of failing reads is reported
+			if !s.failing {
+				p.E("Read metric source %s: %v", strings.TrimSuffix(s.source.Prefix(), "."), err)
+			}
+
+			s.failing = true
+		} else if s.failing {
+			p.V("Metric source %s can be read again", strings.TrimSuffix(s.source.Prefix(), "."))
+
+			s.failing = false
+		}
+
+		// sources can return the values they could read together with an error
+		if d == nil {
+			for i, v := range s.metrics {
+				if s.values != nil {
+					metrics[v] = s.values[i]
+				}
+			}
+
+			continue
+		}
+
+		s.values = d.Values
+
+		for i, v := range s.metrics {
+			metrics[v] = d.Values[i]
+		}
+
+		for name, values := range d.Series {
+			for i, v := range s.metrics {
+				series = append(series, SeriesValue{p.agent.metrics[v].Name, name, values[i]})
+			}
+		}
+
+		tags = append(tags, d.Tags...)
+	}
+
+	return series, tags
+}
+
+// readCollector reads the values of the internal metrics of all clients of the program into the given metrics slice.
+// The values of the clients are aggregated per metric. Metrics which are aggregated per worker are additionally returned as series of the clients.
+func (p *agentProcess) readCollector(metrics []float32) []SeriesValue {
+	p.clientsLock.RLock()
+	defer p.clientsLock.RUnlock()
+
+	var series []SeriesValue
+
+	for k, c := range p.clients {
+		for i, v := range c.metricsCollector.Data() {
+			var m = p.metricsInternal[i]
+
+			switch p.agent.metrics[m].Aggregate {
+			case AggregateMin:
+				if k == 0 || v < metrics[m] {
+					metrics[m] = v
+				}
+			case AggregateMax:
+				if k == 0 || v > metrics[m] {
+					metrics[m] = v
+				}
+			case AggregateWorker:
+				series = append(series, SeriesValue{p.agent.metrics[m].Name, c.name, v})
+
+				metrics[m] += v
+			default:
+				metrics[m] += v
+			}
+		}
+	}
+
+	if len(p.clients) > 1 {
+		for _, m := range p.metricsInternal {
+			if p.agent.metrics[m].Aggregate == AggregateAvg {
+				metrics[m] /= float32(len(p.clients))
+			}
+		}
+	}
+
+	return series
+}
@@ -14,14 +14,24 @@ type Backend interface {
 	FindRun(programName string, runID int32) (*tirion.Run, error)
 	SearchRuns(programName string) ([]tirion.Run, error)
 	StartRun(run *tirion.Run) error
-	StopRun(runID int32) error
+	StopRun(runID int32, reason string) error
+	AddMetrics(runID int32, metrics []tirion.Metric) error
 
 	CreateMetrics(runID int32, metrics []tirion.MessageData) error
 	SearchMetricOfRun(run *tirion.Run, metric string) ([][]interface{}, error)
 	SearchMetricsOfRun(run *tirion.Run) ([][]float32, error)
 
+	CreateSeries(runID int32, series []tirion.MessageSeries) error
+	SearchSeriesOfRun(run *tirion.Run, metric string) (map[string][][]interface{}, error)
+
+	CreateLogs(runID int32, logs []tirion.MessageLog) error
+	SearchLogsOfRun(run *tirion.Run) ([]tirion.LogLine, error)
+
+	CreateSpans(runID int32, spans []tirion.MessageSpan) error
+	SearchSpanStatsOfRun(run *tirion.Run) ([]tirion.SpanStats, error)
+
 	CreateTag(runID int32, tag *tirion.Tag) error
-	SearchTagsOfRun(run *tirion.Run) ([]tirion.HighStockTag, error)
+	SearchTagsOfRun(run *tirion.Run, category string) ([]tirion.HighStockTag, error)
 }
 
 type Parameters struct {
@@ -89,13 +89,13 @@ func (p *Postgresql) FindRun(programName string, runID int32) (*tirion.Run, erro
 		return nil, err
 	}
 
-	row := tx.QueryRow("SELECT id, name, sub_name, interval, metrics, metric_count, prog, prog_arguments, extract(epoch from start), extract(epoch from stop) FROM run WHERE name = $1 and id = $2", programName, runID)
+	row := tx.QueryRow("SELECT id, name, sub_name, interval, metrics, metric_count, prog, prog_arguments, prog_env, prog_env_clear, prog_cwd, prog_stdin, extract(epoch from start), extract(epoch from stop), stop_reason FROM run WHERE name = $1 and id = $2", programName, runID)
 
 	var run = tirion.Run{}
 	var metrics, start string
 	var stop *string
 
-	if err := row.Scan(&run.ID, &run.Name, &run.SubName, &run.Interval, &metrics, &run.MetricCount, &run.Prog, &run.ProgArguments, &start, &stop); err != nil {
+	if err := row.Scan(&run.ID, &run.Name, &run.SubName, &run.Interval, &metrics, &run.MetricCount, &run.Prog, &run.ProgArguments, &run.ProgEnv, &run.ProgEnvClear, &run.ProgCwd, &run.ProgStdin, &start, &stop, &run.StopReason); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -135,7 +135,7 @@ func (p *Postgresql) SearchRuns(programName string) ([]tirion.Run, error) {
 
 	var runs []tirion.Run
 
-	rows, err := tx.Query("SELECT id, name, sub_name, interval, prog, prog_arguments, extract(epoch from start), extract(epoch from stop) FROM run WHERE name = $1 ORDER BY start desc", programName)
+	rows, err := tx.Query("SELECT id, name, sub_name, interval, prog, prog_arguments, prog_env, prog_env_clear, prog_cwd, prog_stdin, extract(epoch from start), extract(epoch from stop), stop_reason FROM run WHERE name = $1 ORDER BY start desc", programName)
 
 	if err != nil {
 		return nil, err
@@ -148,7 +148,7 @@ func (p *Postgresql) SearchRuns(programName string) ([]tirion.Run, error) {
 
 		var start, stop *string
 
-		if err := rows.Scan(&run.ID, &run.Name, &run.SubName, &run.Interval, &run.Prog, &run.ProgArguments, &start, &stop); err != nil {
+		if err := rows.Scan(&run.ID, &run.Name, &run.SubName, &run.Interval, &run.Prog, &run.ProgArguments, &run.ProgEnv, &run.ProgEnvClear, &run.ProgCwd, &run.ProgStdin, &start, &stop, &run.StopReason); err != nil {
 			return nil, err
 		}
 
@@ -189,7 +189,7 @@ func (p *Postgresql) StartRun(run *tirion.Run) error {
 
 	var metrics, _ = json.Marshal(run.Metrics)
 
-	err = tx.QueryRow("INSERT INTO run(name, sub_name, interval, metrics, metric_count, prog, prog_arguments, start) VALUES($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP) RETURNING id", run.Name, run.SubName, run.Interval, string(metrics), len(run.Metrics), run.Prog, run.ProgArguments).Scan(&run.ID)
+	err = tx.QueryRow("INSERT INTO run(name, sub_name, interval, metrics, metric_count, prog, prog_arguments, prog_env, prog_env_clear, prog_cwd, prog_stdin, start) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, CURRENT_TIMESTAMP) RETURNING id", run.Name, run.SubName, run.Interval, string(metrics), len(run.Metrics), run.Prog, run.ProgArguments, run.ProgEnv, run.ProgEnvClear, run.ProgCwd, run.ProgStdin).Scan(&run.ID)
 
 	if err != nil {
 		return err
@@ -198,14 +198,7 @@ func (p *Postgresql) StartRun(run *tirion.Run) error {
 	var columns = make([]string, len(run.Metrics))
 
 	for i, m := range run.Metrics {
-		var n = strings.Replace(m.Name, ".", "_", -1)
-
-		switch m.Type {
-		case "float":
-			columns[i] = n + " REAL NOT NULL"
-		default:
-			columns[i] = n + " " + m.Type + " NOT NULL"
-		}
+		columns[i] = metricColumnDefinition(m)
 	}
 
 	_, err = tx.Exec("CREATE TABLE r" + strconv.FormatInt(int64(run.ID), 10) + "(t TIMESTAMP NOT NULL, " + strings.Join(columns, ",") + ", PRIMARY KEY(t))")
@@ -214,7 +207,25 @@ func (p *Postgresql) StartRun(run *tirion.Run) error {
 		return err
 	}
 
-	_, err = tx.Exec("CREATE TABLE rt" + strconv.FormatInt(int64(run.ID), 10) + "(t TIMESTAMP NOT NULL, message TEXT NOT NULL, PRIMARY KEY(t))")
+	_, err = tx.Exec("CREATE TABLE rt" + strconv.FormatInt(int64(run.ID), 10) + "(id SERIAL, t TIMESTAMP NOT NULL, message TEXT NOT NULL, category TEXT NOT NULL DEFAULT '', severity TEXT NOT NULL DEFAULT '', attributes TEXT NOT NULL DEFAULT '', duration DOUBLE PRECISION NOT NULL DEFAULT 0, PRIMARY KEY(id))")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("CREATE TABLE rs" + strconv.FormatInt(int64(run.ID), 10) + "(t TIMESTAMP NOT NULL, metric TEXT NOT NULL, series TEXT NOT NULL, value REAL NOT NULL, PRIMARY KEY(t, metric, series))")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("CREATE TABLE rl" + strconv.FormatInt(int64(run.ID), 10) + "(id SERIAL, t TIMESTAMP NOT NULL, stream TEXT NOT NULL, line TEXT NOT NULL, PRIMARY KEY(id))")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("CREATE TABLE rp" + strconv.FormatInt(int64(run.ID), 10) + "(id SERIAL, name TEXT NOT NULL, parent TEXT NOT NULL, start TIMESTAMP NOT NULL, stop TIMESTAMP NOT NULL, unfinished BOOLEAN NOT NULL DEFAULT FALSE, PRIMARY KEY(id))")
 
 	if err != nil {
 		return err
@@ -229,7 +240,77 @@ func (p *Postgresql) StartRun(run *tirion.Run) error {
 	return nil
 }
 
-func (p *Postgresql) StopRun(runID int32) error {
+// metricColumn returns the column name of a metric in the metric table of a run
+func metricColumn(name string) string {
+	// "/" separates the program of a metric if a run monitors several programs
+	return strings.Replace(strings.Replace(name, ".", "_", -1), "/", "__", -1)
+}
+
+// metricColumnDefinition returns the definition of the column of a metric in the metric table of a run
+func metricColumnDefinition(m tirion.Metric) string {
+	switch m.Type {
+	case "float":
+		return metricColumn(m.Name) + " REAL NOT NULL"
+	default:
+		return metricColumn(m.Name) + " " + m.Type + " NOT NULL"
+	}
+}
+
+func (p *Postgresql) AddMetrics(runID int32, metrics []tirion.Metric) error {
+	tx, err := p.Db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	var run = tirion.Run{}
+	var runMetrics string
+
+	err = tx.QueryRow("SELECT id, metrics FROM run WHERE id = $1 AND stop IS NULL FOR UPDATE", runID).Scan(&run.ID, &runMetrics)
+
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal([]byte(runMetrics), &run.Metrics)
+
+	if err != nil {
+		return err
+	}
+
+	run.Metrics = append(run.Metrics, metrics...)
+
+	if err := tirion.CheckMetrics(run.Metrics); err != nil {
+		return err
+	}
+
+	// the added columns get the value 0 for the already inserted metrics
+	for _, m := range metrics {
+		_, err = tx.Exec("ALTER TABLE r" + strconv.FormatInt(int64(runID), 10) + " ADD COLUMN " + metricColumnDefinition(m) + " DEFAULT 0")
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var data, _ = json.Marshal(run.Metrics)
+
+	_, err = tx.Exec("UPDATE run SET metrics = $2, metric_count = $3 WHERE id = $1", runID, string(data), len(run.Metrics))
+
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Postgresql) StopRun(runID int32, reason string) error {
 	tx, err := p.Db.Begin()
 
 	if err != nil {
@@ -244,7 +325,7 @@ func (p *Postgresql) StopRun(runID int32) error {
 		return err
 	}
 
-	_, err = tx.Exec("UPDATE run SET stop = CURRENT_TIMESTAMP WHERE id = $1", runID)
+	_, err = tx.Exec("UPDATE run SET stop = CURRENT_TIMESTAMP, stop_reason = $2 WHERE id = $1", runID, reason)
 
 	if err != nil {
 		return err
@@ -274,10 +355,10 @@ func (p *Postgresql) CreateMetrics(runID int32, metrics []tirion.MessageData) er
 		return err
 	}
 
-	// check metrics data before insert to save roundtrips
+	// check metrics data before insert to save roundtrips. data which was fetched before metrics were added to the run has fewer values, the missing values get their column's default
 	for i, m := range metrics {
-		if int32(len(m.Data)) != run.MetricCount {
-			return fmt.Errorf("metric count of %d is unequal to the run's metric count", i)
+		if int32(len(m.Data)) > run.MetricCount {
+			return fmt.Errorf("metric count of %d is greater than the run's metric count", i)
 		}
 	}
 
@@ -331,7 +412,7 @@ func (p *Postgresql) SearchMetricOfRun(run *tirion.Run, metricName string) ([][]
 
 	var metrics [][]interface{}
 
-	rows, err := tx.Query("SELECT EXTRACT(EPOCH FROM t) * 1000.0, " + strings.Replace(metricName, ".", "_", -1) + " FROM r" + strconv.FormatInt(int64(run.ID), 10) + " ORDER BY t")
+	rows, err := tx.Query("SELECT EXTRACT(EPOCH FROM t) * 1000.0, " + metricColumn(metricName) + " FROM r" + strconv.FormatInt(int64(run.ID), 10) + " ORDER BY t")
 
 	if err != nil {
 		return nil, err
@@ -427,6 +508,277 @@ func (p *Postgresql) SearchMetricsOfRun(run *tirion.Run) ([][]float32, error) {
 	return metrics, nil
 }
 
+func (p *Postgresql) CreateSeries(runID int32, series []tirion.MessageSeries) error {
+	tx, err := p.Db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	var run = tirion.Run{}
+
+	err = tx.QueryRow("SELECT id FROM run WHERE id = $1 AND stop IS NULL", runID).Scan(&run.ID)
+
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO rs" + strconv.FormatInt(int64(runID), 10) + "(t, metric, series, value) VALUES(TO_TIMESTAMP($1), $2, $3, $4)")
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, s := range series {
+		var t = float64(s.Time.UnixNano()) / 1000000000.0
+
+		for _, v := range s.Series {
+			_, err = stmt.Exec(t, v.Metric, v.Series, v.Value)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Postgresql) SearchSeriesOfRun(run *tirion.Run, metricName string) (map[string][][]interface{}, error) {
+	var found = false
+
+	for _, m := range run.Metrics {
+		if m.Name == metricName && m.Series {
+			found = true
+
+			break
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("series metric name not found")
+	}
+
+	tx, err := p.Db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var series = make(map[string][][]interface{})
+
+	rows, err := tx.Query("SELECT EXTRACT(EPOCH FROM t) * 1000.0, series, value FROM rs"+strconv.FormatInt(int64(run.ID), 10)+" WHERE metric = $1 ORDER BY t", metricName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var metric = make([]interface{}, 2)
+
+		var m float32
+		var s string
+		var tt float64
+
+		if err := rows.Scan(&tt, &s, &m); err != nil {
+			return nil, err
+		}
+
+		var t = int64(tt)
+
+		metric[0] = &t
+		metric[1] = &m
+
+		series[s] = append(series[s], metric)
+	}
+
+	if err := rows.Err(); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+func (p *Postgresql) CreateLogs(runID int32, logs []tirion.MessageLog) error {
+	tx, err := p.Db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	var run = tirion.Run{}
+
+	err = tx.QueryRow("SELECT id FROM run WHERE id = $1 AND stop IS NULL", runID).Scan(&run.ID)
+
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO rl" + strconv.FormatInt(int64(runID), 10) + "(t, stream, line) VALUES(TO_TIMESTAMP($1), $2, $3)")
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, l := range logs {
+		_, err = stmt.Exec(float64(l.Time.UnixNano())/1000000000.0, l.Stream, l.Line)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Postgresql) SearchLogsOfRun(run *tirion.Run) ([]tirion.LogLine, error) {
+	tx, err := p.Db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []tirion.LogLine
+
+	rows, err := tx.Query("SELECT EXTRACT(EPOCH FROM t) * 1000.0, stream, line FROM rl" + strconv.FormatInt(int64(run.ID), 10) + " ORDER BY id")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var l tirion.LogLine
+		var tt float64
+
+		if err := rows.Scan(&tt, &l.Stream, &l.Line); err != nil {
+			return nil, err
+		}
+
+		l.X = int64(tt)
+
+		logs = append(logs, l)
+	}
+
+	if err := rows.Err(); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+func (p *Postgresql) CreateSpans(runID int32, spans []tirion.MessageSpan) error {
+	tx, err := p.Db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	var run = tirion.Run{}
+
+	err = tx.QueryRow("SELECT id FROM run WHERE id = $1 AND stop IS NULL", runID).Scan(&run.ID)
+
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO rp" + strconv.FormatInt(int64(runID), 10) + "(name, parent, start, stop, unfinished) VALUES($1, $2, TO_TIMESTAMP($3), TO_TIMESTAMP($4), $5)")
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, s := range spans {
+		_, err = stmt.Exec(s.Name, s.Parent, float64(s.Time.UnixNano())/1000000000.0, float64(s.Stop.UnixNano())/1000000000.0, s.Unfinished)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Postgresql) SearchSpanStatsOfRun(run *tirion.Run) ([]tirion.SpanStats, error) {
+	tx, err := p.Db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []tirion.SpanStats
+
+	// spans are ordered by their first start so the phases of a run keep their order
+	rows, err := tx.Query("SELECT name, COUNT(*), SUM(d), MIN(d), MAX(d), AVG(d), SUM(CASE WHEN unfinished THEN 1 ELSE 0 END) FROM (SELECT name, start, unfinished, EXTRACT(EPOCH FROM stop - start) * 1000.0 AS d FROM rp" + strconv.FormatInt(int64(run.ID), 10) + ") AS s GROUP BY name ORDER BY MIN(start)")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var s tirion.SpanStats
+
+		if err := rows.Scan(&s.Name, &s.Count, &s.Total, &s.Min, &s.Max, &s.Avg, &s.Unfinished); err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
 func (p *Postgresql) CreateTag(runID int32, tag *tirion.Tag) error {
 	tx, err := p.Db.Begin()
 
@@ -442,7 +794,19 @@ func (p *Postgresql) CreateTag(runID int32, tag *tirion.Tag) error {
 		return err
 	}
 
-	_, err = tx.Exec("INSERT INTO rt"+strconv.FormatInt(int64(runID), 10)+"(t, message) VALUES(TO_TIMESTAMP($1), $2)", float64(tag.Time.UnixNano())/1000000000.0, tag.Tag)
+	var attributes string
+
+	if len(tag.Attributes) != 0 {
+		a, err := json.Marshal(tag.Attributes)
+
+		if err != nil {
+			return err
+		}
+
+		attributes = string(a)
+	}
+
+	_, err = tx.Exec("INSERT INTO rt"+strconv.FormatInt(int64(runID), 10)+"(t, message, category, severity, attributes, duration) VALUES(TO_TIMESTAMP($1), $2, $3, $4, $5, $6)", float64(tag.Time.UnixNano())/1000000000.0, tag.Tag, tag.Category, tag.Severity, attributes, tag.Duration.Seconds())
 
 	if err != nil {
 		return err
@@ -457,7 +821,7 @@ func (p *Postgresql) CreateTag(runID int32, tag *tirion.Tag) error {
 	return nil
 }
 
-func (p *Postgresql) SearchTagsOfRun(run *tirion.Run) ([]tirion.HighStockTag, error) {
+func (p *Postgresql) SearchTagsOfRun(run *tirion.Run, category string) ([]tirion.HighStockTag, error) {
 	tx, err := p.Db.Begin()
 
 	if err != nil {
@@ -466,7 +830,13 @@ func (p *Postgresql) SearchTagsOfRun(run *tirion.Run) ([]tirion.HighStockTag, er
 
 	var tags []tirion.HighStockTag
 
-	rows, err := tx.Query("SELECT EXTRACT(EPOCH FROM t) * 1000.0, message FROM rt" + strconv.FormatInt(int64(run.ID), 10) + " ORDER BY t")
+	var rows *sql.Rows
+
+	if category != "" {
+		rows, err = tx.Query("SELECT EXTRACT(EPOCH FROM t) * 1000.0, message, category, severity, attributes, duration FROM rt"+strconv.FormatInt(int64(run.ID), 10)+" WHERE category = $1 ORDER BY t, id", category)
+	} else {
+		rows, err = tx.Query("SELECT EXTRACT(EPOCH FROM t) * 1000.0, message, category, severity, attributes, duration FROM rt" + strconv.FormatInt(int64(run.ID), 10) + " ORDER BY t, id")
+	}
 
 	if err != nil {
 		return nil, err
@@ -479,13 +849,29 @@ func (p *Postgresql) SearchTagsOfRun(run *tirion.Run) ([]tirion.HighStockTag, er
 
 		var m string
 		var tt float64
+		var attributes string
+		var duration float64
 
-		if err := rows.Scan(&tt, &m); err != nil {
+		if err := rows.Scan(&tt, &m, &tag.Category, &tag.Severity, &attributes, &duration); err != nil {
 			return nil, err
 		}
 
+		if attributes != "" {
+			if err := json.Unmarshal([]byte(attributes), &tag.Attributes); err != nil {
+				return nil, err
+			}
+		}
+
 		tag.X = int64(tt)
 		tag.Title = m
+		tag.Duration = int64(duration * 1000.0)
+		tag.Text = (&tirion.Tag{
+			Tag:        m,
+			Category:   tag.Category,
+			Severity:   tag.Severity,
+			Attributes: tag.Attributes,
+			Duration:   time.Duration(duration * float64(time.Second)),
+		}).String()
 
 		tags = append(tags, *tag)
 	}
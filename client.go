@@ -1,26 +1,50 @@
 package tirion
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/url"
-	"strconv"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/zimmski/tirion/codec"
 	"github.com/zimmski/tirion/collector"
 )
 
 // Client contains the state of a client.
 type Client struct {
 	Tirion
+	metricNames              map[string]int32
 	metricsCollector         collector.Collector
-	PreferredMetricProtocoll string // which metric protocols should be tried first. default is "shm,mmap"
+	Metrics                  []Metric      // internal metrics declared by the program which are sent to the agent during Init. the agent's metric file is used if no metrics are declared
+	PreferredMetricProtocoll string        // which metric protocols should be tried first. default is "shm,mmap"
+	Paused                   bool          // states if the agent requested to pause the workload of the program
+	Standalone               bool          // states that Init succeeds without an agent. The declared metrics are then kept in the memory of the process
+	AttachInterval           time.Duration // how often a standalone client tries to attach to an agent which is started later. 0 disables attaching. Needs declared metrics
+	Worker                   bool          // states that the client is a further client of the program e.g. in a forked worker process. The process keeps the session and group id of the program
+	collectorLock            sync.RWMutex
+	connLock                 sync.RWMutex // guards the connection to the agent which is set later if the client attaches
+	spanID                   int64
+	unresolved               []*ClientMetric // handles of undeclared metrics of a standalone client which are resolved when the client attaches. guarded by collectorLock
+
+	OnCheckpoint func(name string)   // called if the agent requests the program to dump its state
+	OnPause      func()              // called if the agent requests the program to pause its workload
+	OnResume     func()              // called if the agent requests the program to resume its workload
+	OnStop       func(reason string) // called if the agent requests the program to stop gracefully e.g. before a limit is reached
 }
 
-// NewClient allocates a new Client object
+// NewClient allocates a new Client object. The unix socket path of the environment variable TIRION_SOCKET is used if socket is empty.
 func NewClient(socket string, verbose bool) *Client {
+	if socket == "" {
+		socket = os.Getenv(EnvSocket)
+	}
+
 	return &Client{
 		Tirion: Tirion{
 			socket:    socket,
@@ -34,18 +58,22 @@ func NewClient(socket string, verbose bool) *Client {
 
 // Init initializes the client
 func (c *Client) Init() error {
-	var err error
+	if c.AttachInterval > 0 && len(c.Metrics) == 0 {
+		err := fmt.Errorf("attaching later to an agent needs declared metrics")
 
-	if r, err := syscall.Setsid(); r == -1 {
-		c.E("Cannot set new session and group id of process: %v", err)
+		c.E(err.Error())
 
 		return err
 	}
 
 	c.V("Open unix socket to %s", c.socket)
-	c.fd, err = net.Dial("unix", c.socket)
+	fd, err := net.Dial("unix", c.socket)
 
 	if err != nil {
+		if c.Standalone {
+			return c.initStandalone()
+		}
+
 		if strings.HasSuffix(err.Error(), "use of closed network connection") || strings.HasSuffix(err.Error(), "no such file or directory") {
 			c.E("Cannot open unix socket %s", c.socket)
 		}
@@ -53,86 +81,356 @@ func (c *Client) Init() error {
 		return err
 	}
 
-	c.V("Request tirion protocol version v%s", Version)
-	if err := c.send("tirion v" + Version + "\t" + c.PreferredMetricProtocoll); err != nil {
-		c.E(err.Error())
+	var t = c.newConnection(fd)
+
+	metricsCollector, metricNames, err := c.handshake(t)
+
+	if err != nil {
+		fd.Close()
+
+		return err
+	}
+
+	if err := c.setsid(); err != nil {
+		metricsCollector.Close()
+		fd.Close()
 
 		return err
 	}
 
-	m, err := c.receive()
+	c.metricsCollector = metricsCollector
+	c.metricNames = metricNames
+
+	c.setConnection(t)
+
+	c.Running = true
+
+	// we want to handle commands not in the main thread
+	go c.handleCommands()
+
+	return nil
+}
+
+// setsid moves the process of the program into its own session and process group which is signaled by the agent.
+// Workers must stay in the process group of the program.
+func (c *Client) setsid() error {
+	if c.Worker {
+		return nil
+	}
+
+	if r, err := syscall.Setsid(); r == -1 {
+		c.E("Cannot set new session and group id of process: %v", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// newConnection returns the state of a new connection to the agent which is negotiated by handshake
+func (c *Client) newConnection(fd net.Conn) *Tirion {
+	return &Tirion{
+		fd:        fd,
+		conn:      codec.NewConn(fd),
+		verbose:   c.verbose,
+		logPrefix: c.logPrefix,
+	}
+}
+
+// setConnection makes a negotiated connection the connection of the client
+func (c *Client) setConnection(t *Tirion) {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+
+	c.fd = t.fd
+	c.conn = t.conn
+	c.capabilities = t.capabilities
+}
+
+// initStandalone keeps the declared metrics in the memory of the process as there is no agent
+func (c *Client) initStandalone() error {
+	c.V("Run without an agent")
+
+	var metricsCollector = new(collector.CollectorMemory)
+
+	if err := metricsCollector.InitClient(nil, int32(len(c.Metrics))); err != nil {
+		return err
+	}
+
+	c.metricsCollector = metricsCollector
+	c.metricNames = make(map[string]int32)
+
+	for i, m := range c.Metrics {
+		c.metricNames[m.Name] = int32(i)
+	}
+
+	c.Running = true
+
+	if c.AttachInterval > 0 {
+		go c.attach()
+	}
+
+	return nil
+}
+
+// attach tries to connect to an agent until it succeeds or the client is closed.
+// The values of the metrics are taken over by the metric collector of the agent. The client is attached only after the metric collector is swapped.
+func (c *Client) attach() {
+	var t = time.NewTicker(c.AttachInterval)
+	defer t.Stop()
+
+	for range t.C {
+		if !c.Running {
+			return
+		}
+
+		fd, err := net.Dial("unix", c.socket)
+
+		if err != nil {
+			continue
+		}
+
+		c.V("Attach to agent at %s", c.socket)
+
+		var conn = c.newConnection(fd)
+
+		metricsCollector, metricNames, err := c.handshake(conn)
+
+		if err != nil {
+			c.E("Cannot attach to agent: %v", err)
+
+			fd.Close()
+
+			continue
+		}
+
+		c.collectorLock.Lock()
+
+		// the client was closed while attaching
+		if c.metricsCollector == nil {
+			c.collectorLock.Unlock()
+
+			metricsCollector.Close()
+			fd.Close()
+
+			return
+		}
+
+		// handles of metrics must stay valid so the agent has to use the same indices
+		for name, i := range c.metricNames {
+			if j, ok := metricNames[name]; !ok || i != j {
+				c.collectorLock.Unlock()
+
+				c.E("Cannot attach to agent: internal metric \"%s\" is not defined at index %d", name, i)
+
+				metricsCollector.Close()
+				fd.Close()
+
+				return
+			}
+
+			metricsCollector.Set(i, c.metricsCollector.Get(i))
+		}
+
+		c.metricsCollector.Close()
+
+		c.metricsCollector = metricsCollector
+		c.metricNames = metricNames
+
+		// handles of metrics which were not declared can now address metrics of the agent
+		for _, m := range c.unresolved {
+			if i, ok := metricNames[m.Name]; ok {
+				atomic.StoreInt32(&m.index, i)
+			}
+		}
+
+		c.unresolved = nil
+
+		// a process group leader cannot create a new session but is already signaled with its group
+		c.setsid()
+
+		// the client is attached before the lock is released, so no lookup of an undeclared metric is left unresolved
+		c.setConnection(conn)
+
+		c.collectorLock.Unlock()
+
+		go c.handleCommands()
+
+		return
+	}
+}
+
+// handshake negotiates the protocol with the agent over a new connection and initializes the metric collector of the agent
+func (c *Client) handshake(t *Tirion) (collector.Collector, map[string]int32, error) {
+	var hello = codec.Hello{
+		Version:      Version,
+		Protocols:    strings.Split(c.PreferredMetricProtocoll, ","),
+		Capabilities: capabilities,
+	}
+
+	if len(c.Metrics) != 0 {
+		if err := CheckMetrics(c.Metrics); err != nil {
+			c.E("Declared metrics are invalid: %v", err)
+
+			return nil, nil, err
+		}
+
+		m, err := json.Marshal(c.Metrics)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		c.V("Declare metrics %+v", c.Metrics)
+
+		hello.Metrics = string(m)
+	}
+
+	c.V("Request tirion protocol version v%s with capabilities %v", Version, capabilities)
+	if err := t.send(hello.String()); err != nil {
+		c.E(err.Error())
+
+		return nil, nil, err
+	}
+
+	m, err := t.receive()
 
 	switch err {
 	case nil:
-		var t = strings.SplitN(m, "\t", 2)
-
-		if len(t) == 1 || t[1] == "" {
-			err := fmt.Errorf("did not receive correct metric count and protocol URL")
+		reply, err := codec.ParseReply(m)
 
+		if err != nil {
 			c.E(err.Error())
 
-			return err
+			return nil, nil, err
 		}
 
-		var metricCount, err = strconv.Atoi(t[0])
+		if !codec.Compatible(Version, reply.Version) {
+			err := fmt.Errorf("agent uses incompatible protocol version v%s", reply.Version)
 
-		if err != nil {
-			c.E("Did not receive correct metric count")
+			c.E(err.Error())
 
-			return err
+			return nil, nil, err
 		}
 
-		u, err := url.Parse(t[1])
+		t.capabilities = reply.Capabilities
+
+		c.V("Using tirion protocol version v%s with capabilities %v", reply.Version, t.capabilities)
+
+		var metricCount = reply.MetricCount
+
+		u, err := url.Parse(reply.URL)
 
 		if err != nil {
 			c.E("Did not receive correct protocol URL")
 
-			return err
+			return nil, nil, err
 		}
 
 		c.V("Received metric count %d and protocol URL %v", metricCount, u)
 
-		c.metricsCollector, err = collector.NewCollector(u.Scheme)
+		var metricNames = make(map[string]int32)
+
+		// older agents do not send the names of the internal metrics
+		if len(reply.MetricNames) != 0 {
+			if len(reply.MetricNames) != metricCount {
+				err := fmt.Errorf("received %d metric names for %d metrics", len(reply.MetricNames), metricCount)
+
+				c.E(err.Error())
+
+				return nil, nil, err
+			}
+
+			for i, n := range reply.MetricNames {
+				metricNames[n] = int32(i)
+			}
+
+			c.V("Received metric names %v", reply.MetricNames)
+		}
+
+		metricsCollector, err := collector.NewCollector(u.Scheme)
 
 		if err != nil {
 			c.E("Cannot create metric collector")
 
-			return err
+			return nil, nil, err
 		}
 
-		err = c.metricsCollector.InitClient(u, int32(metricCount))
+		err = metricsCollector.InitClient(u, int32(metricCount))
 
 		if err != nil {
 			c.E("Cannot initialize metrics collector")
 
-			return err
+			return nil, nil, err
 		}
 
 		c.V("Initialized metric collector %s", u.Scheme)
 
-		c.Running = true
-
-		// we want to handle commands not in the main thread
-		go c.handleCommands()
+		return metricsCollector, metricNames, nil
 	case io.EOF:
 		c.V("Unix socket got closed with EOF")
 
-		return err
+		return nil, nil, err
 	default:
 		if strings.HasSuffix(err.Error(), "use of closed network connection") {
 			c.V("Unix socket suddenly got closed")
 		}
 
-		return err
+		return nil, nil, err
 	}
+}
 
-	return nil
+// Attached states if the client is connected to an agent
+func (c *Client) Attached() bool {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+
+	return c.conn != nil
+}
+
+// connection returns the current connection to the agent which is nil if the client is not attached
+func (c *Client) connection() *codec.Conn {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+
+	return c.conn
+}
+
+func (c *Client) receive() (string, error) {
+	var conn = c.connection()
+
+	if conn == nil {
+		return "", fmt.Errorf("not connected")
+	}
+
+	return conn.ReadMessage()
+}
+
+func (c *Client) send(msg string) error {
+	var conn = c.connection()
+
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	return conn.WriteMessage(msg)
+}
+
+// hasCapability states if a capability was negotiated with the agent
+func (c *Client) hasCapability(capability string) bool {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+
+	return c.Tirion.hasCapability(capability)
 }
 
 // Close uninitializes the client by closing all connections of the client.
 func (c *Client) Close() error {
 	c.Running = false
 
+	c.collectorLock.Lock()
+	defer c.collectorLock.Unlock()
+
 	if c.metricsCollector != nil {
 		if err := c.metricsCollector.Close(); err != nil {
 			return err
@@ -141,6 +439,9 @@ func (c *Client) Close() error {
 		c.metricsCollector = nil
 	}
 
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+
 	if c.fd != nil {
 		if err := c.fd.Close(); err != nil {
 			return err
@@ -149,6 +450,9 @@ func (c *Client) Close() error {
 		c.fd = nil
 	}
 
+	c.conn = nil
+	c.capabilities = nil
+
 	return nil
 }
 
@@ -165,9 +469,43 @@ func (c *Client) handleCommands() {
 
 		switch err {
 		case nil:
+			if data == "" {
+				c.E("Empty command")
+
+				continue
+			}
+
 			com := data[0]
 
 			switch com {
+			case commandCheckpoint:
+				c.V("Agent requested checkpoint %s", data[1:])
+
+				if c.OnCheckpoint != nil {
+					c.OnCheckpoint(data[1:])
+				}
+			case commandPause:
+				c.V("Agent requested pause")
+
+				c.Paused = true
+
+				if c.OnPause != nil {
+					c.OnPause()
+				}
+			case commandResume:
+				c.V("Agent requested resume")
+
+				c.Paused = false
+
+				if c.OnResume != nil {
+					c.OnResume()
+				}
+			case commandStop:
+				c.V("Agent requested stop: %s", data[1:])
+
+				if c.OnStop != nil {
+					c.OnStop(data[1:])
+				}
 			default:
 				c.E("Unknown command '%c'", com)
 			}
@@ -193,37 +531,269 @@ func (c *Client) handleCommands() {
 	c.V("Stop listening to commands")
 }
 
-// Get returns the current value of a metric
+// collector returns the current metric collector which is nil if the client is not initialized
+func (c *Client) collector() collector.Collector {
+	c.collectorLock.RLock()
+	defer c.collectorLock.RUnlock()
+
+	return c.metricsCollector
+}
+
+// Get returns the current value of a metric. 0.0 is returned if the client is not initialized.
 func (c *Client) Get(i int32) float32 {
-	return c.metricsCollector.Get(i)
+	if m := c.collector(); m != nil {
+		return m.Get(i)
+	}
+
+	return 0.0
 }
 
 // Set sets a value for a metric
 func (c *Client) Set(i int32, v float32) float32 {
-	return c.metricsCollector.Set(i, v)
+	if m := c.collector(); m != nil {
+		return m.Set(i, v)
+	}
+
+	return 0.0
 }
 
 // Add adds a value to a metric
 func (c *Client) Add(i int32, v float32) float32 {
-	return c.metricsCollector.Add(i, v)
+	if m := c.collector(); m != nil {
+		return m.Add(i, v)
+	}
+
+	return 0.0
 }
 
 // Dec decrements a metric by 1.0
 func (c *Client) Dec(i int32) float32 {
-	return c.metricsCollector.Dec(i)
+	if m := c.collector(); m != nil {
+		return m.Dec(i)
+	}
+
+	return 0.0
 }
 
 // Inc increments a metric by 1.0
 func (c *Client) Inc(i int32) float32 {
-	return c.metricsCollector.Inc(i)
+	if m := c.collector(); m != nil {
+		return m.Inc(i)
+	}
+
+	return 0.0
 }
 
 // Sub subtracts a value of a metric
 func (c *Client) Sub(i int32, v float32) float32 {
-	return c.metricsCollector.Sub(i, v)
+	if m := c.collector(); m != nil {
+		return m.Sub(i, v)
+	}
+
+	return 0.0
+}
+
+// LookupMetric returns the handle of the internal metric with the given name.
+// An error is returned if the agent did not define an internal metric with this name.
+// A standalone client which is not attached to an agent returns a handle which does nothing for undeclared metrics until the client attaches.
+func (c *Client) LookupMetric(name string) (*ClientMetric, error) {
+	c.collectorLock.Lock()
+	defer c.collectorLock.Unlock()
+
+	i, ok := c.metricNames[name]
+
+	if !ok {
+		if c.Standalone && !c.Attached() {
+			var m = &ClientMetric{
+				client: c,
+				index:  -1,
+				Name:   name,
+			}
+
+			c.unresolved = append(c.unresolved, m)
+
+			return m, nil
+		}
+
+		return nil, fmt.Errorf("internal metric \"%s\" is not defined by the agent", name)
+	}
+
+	return &ClientMetric{
+		client: c,
+		index:  i,
+		Name:   name,
+	}, nil
+}
+
+// Metric returns the handle of the internal metric with the given name.
+// Metric panics if the agent did not define an internal metric with this name.
+func (c *Client) Metric(name string) *ClientMetric {
+	m, err := c.LookupMetric(name)
+
+	if err != nil {
+		c.E(err.Error())
+
+		panic(err)
+	}
+
+	return m
 }
 
 // Tag sends a tag to the agent
 func (c *Client) Tag(format string, a ...interface{}) {
 	c.send(PrepareTag(fmt.Sprintf("t"+format, a...)))
 }
+
+// TagStructured sends a tag with a category, severity, attributes and duration to the agent.
+// The time of the tag is set by the agent if it is zero. Agents which do not support structured tags receive the tag as plain text.
+func (c *Client) TagStructured(tag Tag) error {
+	if err := CheckTag(&tag); err != nil {
+		c.E("Structured tag is invalid: %v", err)
+
+		return err
+	}
+
+	if !c.hasCapability("structured-tag") {
+		return c.send("t" + PrepareTag(tag.String()))
+	}
+
+	tag.Tag = PrepareTag(tag.Tag)
+
+	data, err := json.Marshal(tag)
+
+	if err != nil {
+		return err
+	}
+
+	return c.send("T" + string(data))
+}
+
+// StartSpan starts a span which times a region of the program e.g. a phase like "parse". The span is recorded when it ends.
+func (c *Client) StartSpan(name string) *Span {
+	return c.startSpan(name, nil)
+}
+
+func (c *Client) startSpan(name string, parent *Span) *Span {
+	var s = &Span{
+		client: c,
+		id:     atomic.AddInt64(&c.spanID, 1),
+		parent: parent,
+		Name:   name,
+		Start:  time.Now(),
+	}
+
+	if parent != nil {
+		s.Name = parent.Name + "/" + name
+	}
+
+	if c.hasCapability("span") {
+		var m = spanMessage{
+			ID:   s.id,
+			Name: name,
+			Time: s.Start,
+		}
+
+		if parent != nil {
+			m.Parent = parent.id
+		}
+
+		data, _ := json.Marshal(m)
+
+		c.send("S" + string(data))
+	}
+
+	return s
+}
+
+// Span is a timed region of the program which can be nested.
+type Span struct {
+	client *Client
+	ended  bool
+	id     int64
+	parent *Span
+	Name   string // names of the span and its parents e.g. "request/parse"
+	Start  time.Time
+}
+
+// StartSpan starts a span which is nested in this span
+func (s *Span) StartSpan(name string) *Span {
+	return s.client.startSpan(name, s)
+}
+
+// End ends the span and returns its duration. Spans can only be ended once.
+func (s *Span) End() time.Duration {
+	var stop = time.Now()
+	var d = stop.Sub(s.Start)
+
+	if s.ended {
+		return d
+	}
+
+	s.ended = true
+
+	if s.client.hasCapability("span") {
+		data, _ := json.Marshal(spanMessage{
+			ID:   s.id,
+			Time: stop,
+		})
+
+		s.client.send("E" + string(data))
+	} else {
+		// agents without spans still get the span as tag
+		s.client.TagStructured(Tag{
+			Time:     s.Start,
+			Tag:      s.Name,
+			Category: TagCategorySpan,
+			Duration: d,
+		})
+	}
+
+	return d
+}
+
+// ClientMetric is a handle to an internal metric of a client which is addressed by the metric's name instead of its index.
+type ClientMetric struct {
+	client *Client
+	index  int32 // -1 for an undeclared metric of a standalone client. accessed atomically as it is resolved when the client attaches
+	Name   string // name of the internal metric
+}
+
+// Index returns the index of the internal metric
+func (m *ClientMetric) Index() int32 {
+	return atomic.LoadInt32(&m.index)
+}
+
+// Get returns the current value of the metric
+func (m *ClientMetric) Get() float32 {
+	return m.client.Get(m.Index())
+}
+
+// Set sets a value for the metric
+func (m *ClientMetric) Set(v float32) float32 {
+	return m.client.Set(m.Index(), v)
+}
+
+// Observe records an observed value for the metric. As metrics are sampled by the agent this is the same as Set.
+func (m *ClientMetric) Observe(v float32) float32 {
+	return m.client.Set(m.Index(), v)
+}
+
+// Add adds a value to the metric
+func (m *ClientMetric) Add(v float32) float32 {
+	return m.client.Add(m.Index(), v)
+}
+
+// Dec decrements the metric by 1.0
+func (m *ClientMetric) Dec() float32 {
+	return m.client.Dec(m.Index())
+}
+
+// Inc increments the metric by 1.0
+func (m *ClientMetric) Inc() float32 {
+	return m.client.Inc(m.Index())
+}
+
+// Sub subtracts a value of the metric
+func (m *ClientMetric) Sub(v float32) float32 {
+	return m.client.Sub(m.Index(), v)
+}
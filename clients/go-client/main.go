@@ -15,17 +15,19 @@ func main() {
 	var flagMmap bool
 	var flagRuntime int
 	var flagSocket string
+	var flagStandalone bool
 	var flagVerbose bool
 
 	flag.BoolVar(&flagHelp, "help", false, "Show this help")
 	flag.BoolVar(&flagMmap, "mmap", false, "Use Mmap as metric protocol")
 	flag.IntVar(&flagRuntime, "runtime", 5, "Runtime of the example client in seconds")
-	flag.StringVar(&flagSocket, "socket", "/tmp/tirion.sock", "Unix socket path for client<-->agent communication")
+	flag.StringVar(&flagSocket, "socket", "", "Unix socket path for client<-->agent communication (defaults to the environment variable "+tirion.EnvSocket+")")
+	flag.BoolVar(&flagStandalone, "standalone", false, "Run without an agent if the unix socket cannot be opened")
 	flag.BoolVar(&flagVerbose, "verbose", false, "Verbose output of what is going on")
 
 	flag.Parse()
 
-	if flagSocket == "" || flagHelp {
+	if (flagSocket == "" && os.Getenv(tirion.EnvSocket) == "") || flagHelp {
 		fmt.Printf("Tirion go example client v%s\n", tirion.Version)
 		fmt.Printf("usage: %s [options]\n", os.Args[0])
 		fmt.Printf("options\n")
@@ -44,6 +46,8 @@ func main() {
 		c.PreferredMetricProtocoll = "mmap"
 	}
 
+	c.Standalone = flagStandalone
+
 	if err := c.Init(); err != nil {
 		panic(err)
 	}
@@ -54,12 +58,25 @@ func main() {
 		c.Close()
 	})
 
+	c.TagStructured(tirion.Tag{
+		Tag:        "start",
+		Category:   tirion.TagCategoryPhase,
+		Severity:   tirion.SeverityInfo,
+		Attributes: map[string]string{"runtime": fmt.Sprintf("%ds", flagRuntime)},
+	})
+
+	var a = c.Metric("a")
+	var b = c.Metric("b")
+	var cc = c.Metric("c")
+	var d = c.Metric("d")
+	var e = c.Metric("e")
+
 	for c.Running {
-		r := c.Inc(0)
-		c.Dec(1)
-		c.Add(2, 0.3)
-		c.Sub(3, 0.3)
-		c.Set(4, c.Get(4)+4)
+		r := a.Inc()
+		b.Dec()
+		cc.Add(0.3)
+		d.Sub(0.3)
+		e.Set(e.Get() + 4)
 
 		time.Sleep(10 * time.Millisecond)
 
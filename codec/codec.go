@@ -0,0 +1,344 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FramedVersion is the first protocol version which frames every message as one line.
+// Clients of older versions send one message per write without a terminator.
+const FramedVersion = "0.4"
+
+const readSize = 4096
+
+// Compatibility holds for every protocol version the versions of the other side it can talk to
+var Compatibility = map[string][]string{
+	"0.3": {"0.3"},
+	"0.4": {"0.3", "0.4"},
+}
+
+// Conn frames the messages of the client<->agent protocol on top of a stream connection.
+type Conn struct {
+	conn    net.Conn
+	framed  bool
+	pending []byte
+	wmu     sync.Mutex
+}
+
+// NewConn allocates a new framed Conn object
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:   conn,
+		framed: true,
+	}
+}
+
+// Framed states if the messages of the other side are framed
+func (c *Conn) Framed() bool {
+	return c.framed
+}
+
+var helloVersionRegex = regexp.MustCompile(`^tirion v([0-9.]+)[^0-9.]`)
+var helloPrefixRegex = regexp.MustCompile(`^tirion v[0-9.]*$`)
+
+// ReadHello reads the first message of a client and detects if the client frames its messages.
+// The hello can arrive in several reads, so reading goes on until it ends with a newline or its version is complete.
+func (c *Conn) ReadHello() (string, error) {
+	for {
+		var buf = make([]byte, readSize)
+
+		n, err := c.conn.Read(buf)
+
+		if n > 0 {
+			c.pending = append(c.pending, buf[:n]...)
+		}
+
+		if bytes.IndexByte(c.pending, '\n') != -1 {
+			return c.ReadMessage()
+		} else if err != nil {
+			if err == io.EOF && len(c.pending) > 0 {
+				return c.unframedHello(), nil
+			}
+
+			return "", err
+		}
+
+		if m := helloVersionRegex.FindSubmatch(c.pending); m != nil {
+			// old clients send the hello without a newline but never more than the hello
+			if CompareVersions(string(m[1]), FramedVersion) < 0 {
+				return c.unframedHello(), nil
+			}
+		} else if !strings.HasPrefix("tirion v", string(c.pending)) && !helloPrefixRegex.Match(c.pending) {
+			// this is no hello at all which is reported by parsing it
+			return c.unframedHello(), nil
+		}
+	}
+}
+
+// unframedHello returns the pending data as the hello of a client which does not frame its messages
+func (c *Conn) unframedHello() string {
+	c.framed = false
+
+	var hello = string(c.pending)
+
+	c.pending = nil
+
+	return hello
+}
+
+// ReadMessage reads the next message. The message does not include its terminator.
+func (c *Conn) ReadMessage() (string, error) {
+	if !c.framed {
+		// every read is one message
+		var buf = make([]byte, readSize)
+
+		n, err := c.conn.Read(buf)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.Trim(string(buf[:n]), "\n"), nil
+	}
+
+	for {
+		if i := bytes.IndexByte(c.pending, '\n'); i != -1 {
+			var msg = string(c.pending[:i])
+
+			c.pending = c.pending[i+1:]
+
+			return msg, nil
+		}
+
+		var buf = make([]byte, readSize)
+
+		n, err := c.conn.Read(buf)
+
+		if n > 0 {
+			c.pending = append(c.pending, buf[:n]...)
+		}
+
+		if err != nil {
+			// a last message without terminator is still a message
+			if err == io.EOF && len(c.pending) > 0 {
+				var msg = string(c.pending)
+
+				c.pending = nil
+
+				return msg, nil
+			}
+
+			return "", err
+		}
+	}
+}
+
+// WriteMessage writes a message followed by its terminator. It can be called concurrently.
+func (c *Conn) WriteMessage(msg string) error {
+	if strings.ContainsRune(msg, '\n') {
+		return fmt.Errorf("message must not contain newlines")
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	_, err := c.conn.Write([]byte(msg + "\n"))
+
+	return err
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Negotiate returns the protocol version which is used with the other side.
+// If the other side is newer, the own version is used and the other side has to decide if it can talk to it.
+func Negotiate(own string, other string) (string, error) {
+	for _, v := range Compatibility[own] {
+		if v == other {
+			return other, nil
+		}
+	}
+
+	if CompareVersions(other, own) > 0 {
+		return own, nil
+	}
+
+	return "", fmt.Errorf("protocol version v%s is not compatible with v%s", other, own)
+}
+
+// Compatible states if a version can talk to the other version
+func Compatible(own string, other string) bool {
+	for _, v := range Compatibility[own] {
+		if v == other {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CompareVersions compares two versions like "0.3" and returns -1, 0 or 1
+func CompareVersions(a string, b string) int {
+	var as = strings.Split(a, ".")
+	var bs = strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// Intersect returns all capabilities which are supported by both sides
+func Intersect(a []string, b []string) []string {
+	var supported = make(map[string]bool)
+
+	for _, c := range b {
+		supported[c] = true
+	}
+
+	var r []string
+
+	for _, c := range a {
+		if supported[c] {
+			r = append(r, c)
+		}
+	}
+
+	return r
+}
+
+// Hello contains the first message of a client.
+type Hello struct {
+	Version      string
+	Protocols    []string // preferred metric protocols
+	Capabilities []string // since v0.4
+	Metrics      string   // optional JSON of the declared metrics
+}
+
+var helloRegex = regexp.MustCompile("^tirion v([0-9.]+)\t([a-z,]+)(?:\t(.*))?$")
+
+// ParseHello parses the first message of a client
+func ParseHello(msg string) (*Hello, error) {
+	var m = helloRegex.FindStringSubmatch(strings.Trim(msg, "\n"))
+
+	if m == nil {
+		return nil, fmt.Errorf("client did not send tirion protocol version")
+	}
+
+	var h = &Hello{
+		Version:   m[1],
+		Protocols: strings.Split(m[2], ","),
+	}
+
+	if CompareVersions(h.Version, FramedVersion) < 0 {
+		h.Metrics = m[3]
+
+		return h, nil
+	}
+
+	// tirion v<version>\t<protocols>\t<capabilities>[\t<metrics>]
+	var f = strings.SplitN(m[3], "\t", 2)
+
+	h.Capabilities = splitList(f[0])
+
+	if len(f) == 2 {
+		h.Metrics = f[1]
+	}
+
+	return h, nil
+}
+
+// String formats the hello for the protocol version of the hello
+func (h *Hello) String() string {
+	var s = "tirion v" + h.Version + "\t" + strings.Join(h.Protocols, ",")
+
+	if CompareVersions(h.Version, FramedVersion) >= 0 {
+		s += "\t" + strings.Join(h.Capabilities, ",")
+	}
+	if h.Metrics != "" {
+		s += "\t" + h.Metrics
+	}
+
+	return s
+}
+
+// Reply contains the answer of the agent to the hello of a client.
+type Reply struct {
+	MetricCount  int
+	URL          string   // URL of the metric protocol
+	MetricNames  []string // names of the internal metrics. older agents do not send them
+	Version      string   // negotiated protocol version. older agents do not send it
+	Capabilities []string // negotiated capabilities. older agents do not send them
+}
+
+// ParseReply parses the answer of an agent
+func ParseReply(msg string) (*Reply, error) {
+	var t = strings.Split(strings.Trim(msg, "\n"), "\t")
+
+	if len(t) < 2 || t[1] == "" {
+		return nil, fmt.Errorf("did not receive correct metric count and protocol URL")
+	}
+
+	metricCount, err := strconv.Atoi(t[0])
+
+	if err != nil {
+		return nil, fmt.Errorf("did not receive correct metric count")
+	}
+
+	var r = &Reply{
+		MetricCount: metricCount,
+		URL:         t[1],
+		Version:     "0.3",
+	}
+
+	if len(t) > 2 {
+		r.MetricNames = splitList(t[2])
+	}
+	if len(t) > 4 {
+		r.Version = t[3]
+		r.Capabilities = splitList(t[4])
+	}
+
+	return r, nil
+}
+
+// String formats the reply for the negotiated protocol version. Clients older than v0.4 only understand the metric count and the URL.
+func (r *Reply) String() string {
+	var s = fmt.Sprintf("%d\t%s", r.MetricCount, r.URL)
+
+	if CompareVersions(r.Version, FramedVersion) >= 0 {
+		s += "\t" + strings.Join(r.MetricNames, ",") + "\t" + r.Version + "\t" + strings.Join(r.Capabilities, ",")
+	}
+
+	return s
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
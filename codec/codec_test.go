@@ -0,0 +1,222 @@
+package codec
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestHello(t *testing.T) {
+	var tests = []struct {
+		name  string
+		hello Hello
+		msg   string
+	}{
+		{
+			name:  "v0.3",
+			hello: Hello{Version: "0.3", Protocols: []string{"shm", "mmap"}},
+			msg:   "tirion v0.3\tshm,mmap",
+		},
+		{
+			name:  "v0.4 without metrics",
+			hello: Hello{Version: "0.4", Protocols: []string{"shm"}, Capabilities: []string{"tag", "command"}},
+			msg:   "tirion v0.4\tshm\ttag,command",
+		},
+		{
+			name:  "v0.4 without capabilities",
+			hello: Hello{Version: "0.4", Protocols: []string{"mmap"}},
+			msg:   "tirion v0.4\tmmap\t",
+		},
+		{
+			name:  "v0.4 with metrics",
+			hello: Hello{Version: "0.4", Protocols: []string{"shm", "mmap"}, Capabilities: []string{"tag"}, Metrics: `[{"Name":"a","Type":"int"}]`},
+			msg:   "tirion v0.4\tshm,mmap\ttag\t[{\"Name\":\"a\",\"Type\":\"int\"}]",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.hello.String(); got != test.msg {
+			t.Errorf("%s: String() = %q, want %q", test.name, got, test.msg)
+		}
+
+		h, err := ParseHello(test.msg)
+
+		if err != nil {
+			t.Errorf("%s: ParseHello(%q) failed: %v", test.name, test.msg, err)
+		} else if !reflect.DeepEqual(*h, test.hello) {
+			t.Errorf("%s: ParseHello(%q) = %+v, want %+v", test.name, test.msg, *h, test.hello)
+		}
+	}
+}
+
+func TestParseHelloInvalid(t *testing.T) {
+	for _, msg := range []string{"", "tirion", "tirion v0.4", "hello v0.4\tshm\t"} {
+		if _, err := ParseHello(msg); err == nil {
+			t.Errorf("ParseHello(%q) did not fail", msg)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	var tests = []struct {
+		name   string
+		reply  Reply
+		msg    string
+		parsed Reply
+	}{
+		{
+			name:   "v0.3 reply has only count and URL",
+			reply:  Reply{MetricCount: 2, URL: "shm:///proc/1", MetricNames: []string{"a", "b"}, Version: "0.3", Capabilities: []string{"tag"}},
+			msg:    "2\tshm:///proc/1",
+			parsed: Reply{MetricCount: 2, URL: "shm:///proc/1", Version: "0.3"},
+		},
+		{
+			name:   "v0.4",
+			reply:  Reply{MetricCount: 2, URL: "mmap:///tmp/tirion-1.mmap", MetricNames: []string{"a", "b"}, Version: "0.4", Capabilities: []string{"tag", "span"}},
+			msg:    "2\tmmap:///tmp/tirion-1.mmap\ta,b\t0.4\ttag,span",
+			parsed: Reply{MetricCount: 2, URL: "mmap:///tmp/tirion-1.mmap", MetricNames: []string{"a", "b"}, Version: "0.4", Capabilities: []string{"tag", "span"}},
+		},
+		{
+			name:   "v0.4 without metrics and capabilities",
+			reply:  Reply{MetricCount: 0, URL: "shm:///proc/1", Version: "0.4"},
+			msg:    "0\tshm:///proc/1\t\t0.4\t",
+			parsed: Reply{MetricCount: 0, URL: "shm:///proc/1", Version: "0.4"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.reply.String(); got != test.msg {
+			t.Errorf("%s: String() = %q, want %q", test.name, got, test.msg)
+		}
+
+		r, err := ParseReply(test.msg)
+
+		if err != nil {
+			t.Errorf("%s: ParseReply(%q) failed: %v", test.name, test.msg, err)
+		} else if !reflect.DeepEqual(*r, test.parsed) {
+			t.Errorf("%s: ParseReply(%q) = %+v, want %+v", test.name, test.msg, *r, test.parsed)
+		}
+	}
+}
+
+func TestParseReplyInvalid(t *testing.T) {
+	for _, msg := range []string{"", "2", "2\t", "x\tshm:///proc/1"} {
+		if _, err := ParseReply(msg); err == nil {
+			t.Errorf("ParseReply(%q) did not fail", msg)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	var tests = []struct {
+		own     string
+		other   string
+		version string
+		fails   bool
+	}{
+		{"0.4", "0.4", "0.4", false},
+		{"0.4", "0.3", "0.3", false},
+		{"0.4", "0.5", "0.4", false},
+		{"0.4", "0.2", "", true},
+		{"0.3", "0.4", "0.3", false},
+	}
+
+	for _, test := range tests {
+		version, err := Negotiate(test.own, test.other)
+
+		if test.fails != (err != nil) || version != test.version {
+			t.Errorf("Negotiate(%q, %q) = %q, %v, want %q", test.own, test.other, version, err, test.version)
+		}
+	}
+}
+
+func TestReadHello(t *testing.T) {
+	var tests = []struct {
+		name     string
+		writes   []string
+		hello    string
+		framed   bool
+		messages []string
+	}{
+		{
+			name:   "v0.3 in one write",
+			writes: []string{"tirion v0.3\tshm,mmap", "tag"},
+			hello:  "tirion v0.3\tshm,mmap",
+			framed: false,
+			// every write of an old client is one message
+			messages: []string{"tag"},
+		},
+		{
+			name:     "v0.3 with newline",
+			writes:   []string{"tirion v0.3\tshm,mmap\n", "tag\n"},
+			hello:    "tirion v0.3\tshm,mmap",
+			framed:   true,
+			messages: []string{"tag"},
+		},
+		{
+			name:     "v0.3 with version cut off",
+			writes:   []string{"tirion v0.", "3\tshm,mmap", "tag"},
+			hello:    "tirion v0.3\tshm,mmap",
+			framed:   false,
+			messages: []string{"tag"},
+		},
+		{
+			name:     "v0.4 in one write",
+			writes:   []string{"tirion v0.4\tshm\ttag\ntfirst\ntsecond\n"},
+			hello:    "tirion v0.4\tshm\ttag",
+			framed:   true,
+			messages: []string{"tfirst", "tsecond"},
+		},
+		{
+			name:     "v0.4 with version cut off",
+			writes:   []string{"tirion v0", ".4", "\tshm\ttag", "\n", "tfirst\n"},
+			hello:    "tirion v0.4\tshm\ttag",
+			framed:   true,
+			messages: []string{"tfirst"},
+		},
+		{
+			name:     "v0.4 cut before the version",
+			writes:   []string{"tiri", "on v", "0.4\tshm\t\ntfirst", "\n"},
+			hello:    "tirion v0.4\tshm\t",
+			framed:   true,
+			messages: []string{"tfirst"},
+		},
+		{
+			name:   "no hello",
+			writes: []string{"hello"},
+			hello:  "hello",
+			framed: false,
+		},
+	}
+
+	for _, test := range tests {
+		client, agent := net.Pipe()
+
+		go func(writes []string) {
+			for _, w := range writes {
+				client.Write([]byte(w))
+			}
+		}(test.writes)
+
+		var conn = NewConn(agent)
+
+		hello, err := conn.ReadHello()
+
+		if err != nil {
+			t.Errorf("%s: ReadHello failed: %v", test.name, err)
+		} else if hello != test.hello {
+			t.Errorf("%s: ReadHello = %q, want %q", test.name, hello, test.hello)
+		} else if conn.Framed() != test.framed {
+			t.Errorf("%s: Framed = %t, want %t", test.name, conn.Framed(), test.framed)
+		} else {
+			for _, e := range test.messages {
+				if m, err := conn.ReadMessage(); err != nil || m != e {
+					t.Errorf("%s: ReadMessage = %q, %v, want %q", test.name, m, err, e)
+				}
+			}
+		}
+
+		client.Close()
+		agent.Close()
+	}
+}
@@ -6,7 +6,7 @@ import (
 )
 
 type Collector interface {
-	InitAgent(pid int32, metricCount int32) (*url.URL, error)
+	InitAgent(pid int32, client int32, metricCount int32) (*url.URL, error)
 	InitClient(u *url.URL, metricCount int32) error
 	Data() []float32
 	Close() error
@@ -20,6 +20,15 @@ type Collector interface {
 	Sub(i int32, v float32) float32
 }
 
+// allocatedCount returns how many metrics are allocated for a shared collector. Empty memory cannot be shared, so at least one metric is allocated.
+func allocatedCount(count int32) int32 {
+	if count < 1 {
+		return 1
+	}
+
+	return count
+}
+
 func NewCollector(typ string) (Collector, error) {
 	switch typ {
 	case "mmap":
@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// CollectorMemory keeps the metrics in the memory of the process. It is used by clients which run without an agent and is therefore not a metric protocol of NewCollector.
+type CollectorMemory struct {
+	count int32
+	data  []float32
+	lock  sync.Mutex
+}
+
+func (c *CollectorMemory) InitAgent(pid int32, client int32, metricCount int32) (*url.URL, error) {
+	return nil, fmt.Errorf("memory collector cannot be shared with a client")
+}
+
+func (c *CollectorMemory) InitClient(u *url.URL, metricCount int32) error {
+	c.count = metricCount
+	c.data = make([]float32, metricCount)
+
+	return nil
+}
+
+func (c *CollectorMemory) Data() []float32 {
+	a := make([]float32, c.count)
+
+	c.lock.Lock()
+
+	copy(a, c.data)
+
+	c.lock.Unlock()
+
+	return a
+}
+
+func (c *CollectorMemory) Close() error {
+	return nil
+}
+
+func (c *CollectorMemory) Get(i int32) float32 {
+	if i < 0 || i >= c.count {
+		return 0.0
+	}
+
+	c.lock.Lock()
+
+	ret := c.data[i]
+
+	c.lock.Unlock()
+
+	return ret
+}
+
+func (c *CollectorMemory) Set(i int32, v float32) float32 {
+	if i < 0 || i >= c.count {
+		return 0.0
+	}
+
+	c.lock.Lock()
+
+	c.data[i] = v
+
+	c.lock.Unlock()
+
+	return v
+}
+
+func (c *CollectorMemory) Add(i int32, v float32) float32 {
+	if i < 0 || i >= c.count {
+		return 0.0
+	}
+
+	c.lock.Lock()
+
+	c.data[i] += v
+	ret := c.data[i]
+
+	c.lock.Unlock()
+
+	return ret
+}
+
+func (c *CollectorMemory) Dec(i int32) float32 {
+	return c.Add(i, -1.0)
+}
+
+func (c *CollectorMemory) Inc(i int32) float32 {
+	return c.Add(i, 1.0)
+}
+
+func (c *CollectorMemory) Sub(i int32, v float32) float32 {
+	return c.Add(i, -v)
+}
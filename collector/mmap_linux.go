@@ -22,10 +22,11 @@ type CollectorMmap struct {
 	lock     sync.Mutex
 }
 
-func (c *CollectorMmap) InitAgent(pid int32, metricCount int32) (*url.URL, error) {
+func (c *CollectorMmap) InitAgent(pid int32, client int32, metricCount int32) (*url.URL, error) {
+	// every client of a process gets its own file
 	var u = &url.URL{
 		Scheme: "mmap",
-		Path:   fmt.Sprintf("%s/tirion-%d.mmap", os.TempDir(), pid),
+		Path:   fmt.Sprintf("%s/tirion-%d-%d.mmap", os.TempDir(), pid, client),
 	}
 
 	err := c.initMmap(u.Path, true, metricCount)
@@ -66,7 +67,7 @@ func (c *CollectorMmap) initMmap(filename string, create bool, count int32) erro
 	 * this would make it possible to use indizes to access
 	 * the array elements.
 	 */
-	c.addr = C.mmapOpen(f, cr, C.long(count))
+	c.addr = C.mmapOpen(f, cr, C.long(allocatedCount(count)))
 
 	if c.addr == nil {
 		return fmt.Errorf("cannot open mmap")
@@ -78,6 +79,10 @@ func (c *CollectorMmap) initMmap(filename string, create bool, count int32) erro
 func (c *CollectorMmap) Data() []float32 {
 	a := make([]float32, c.count)
 
+	if c.count == 0 {
+		return a
+	}
+
 	C.mmapCopy(c.addr, (*C.float)(unsafe.Pointer(&a[0])), C.long(c.count))
 
 	return a
@@ -95,7 +100,7 @@ func (c *CollectorMmap) Close() error {
 		cr = C.char(0)
 	}
 
-	if C.mmapClose(c.addr, f, cr, C.long(c.count)) != 0 {
+	if C.mmapClose(c.addr, f, cr, C.long(allocatedCount(c.count))) != 0 {
 		return fmt.Errorf("mmap close error")
 	}
 
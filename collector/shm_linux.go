@@ -15,22 +15,36 @@ import (
 )
 
 type CollectorShm struct {
-	id     int32
-	create bool
-	addr   *C.float
-	count  int32
-	lock   sync.Mutex
+	id       int32
+	create   bool
+	addr     *C.float
+	count    int32
+	filename string
+	lock     sync.Mutex
 }
 
-func (c *CollectorShm) InitAgent(pid int32, metricCount int32) (*url.URL, error) {
+func (c *CollectorShm) InitAgent(pid int32, client int32, metricCount int32) (*url.URL, error) {
+	// the key of the shared memory is derived from a file, so every client of a process gets its own file
 	var u = &url.URL{
 		Scheme: "shm",
-		Path:   fmt.Sprintf("/proc/%d", pid),
+		Path:   fmt.Sprintf("%s/tirion-%d-%d.shm", os.TempDir(), pid, client),
 	}
 
-	err := c.initShm(u.Path, true, metricCount)
+	f, err := os.OpenFile(u.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 
 	if err != nil {
+		return nil, fmt.Errorf("cannot create shm key file: %v", err)
+	}
+
+	f.Close()
+
+	c.filename = u.Path
+
+	err = c.initShm(u.Path, true, metricCount)
+
+	if err != nil {
+		os.Remove(u.Path)
+
 		return nil, err
 	}
 
@@ -60,7 +74,7 @@ func (c *CollectorShm) initShm(filename string, create bool, count int32) error
 		cr = C.char(0)
 	}
 
-	c.id = int32(C.shmOpen(f, cr, C.long(c.count)))
+	c.id = int32(C.shmOpen(f, cr, C.long(allocatedCount(c.count))))
 
 	if c.id == -1 {
 		return fmt.Errorf("shm open error")
@@ -79,6 +93,10 @@ func (c *CollectorShm) initShm(filename string, create bool, count int32) error
 func (c *CollectorShm) Data() []float32 {
 	a := make([]float32, c.count)
 
+	if c.count == 0 {
+		return a
+	}
+
 	C.shmCopy(c.addr, (*C.float)(unsafe.Pointer(&a[0])), C.long(c.count))
 
 	return a
@@ -88,6 +106,8 @@ func (c *CollectorShm) Close() error {
 	C.shmDetach(c.addr)
 
 	if c.create {
+		os.Remove(c.filename)
+
 		if C.shmClose(C.long(c.id)) != 0 {
 			return fmt.Errorf("shm close error")
 		}
@@ -0,0 +1,378 @@
+package tirion
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Expression is a parsed expression of a derived metric.
+// Expressions consist of numbers, metric names, the operators +, -, * and / as well as parentheses and the following functions:
+//
+//	rate(x)     change of x per second since the last evaluation
+//	delta(x)    change of x since the last evaluation
+//	abs(x)      absolute value of x
+//	min(x, y)   minimum of x and y
+//	max(x, y)   maximum of x and y
+//
+// As metric names can contain "-" a subtraction must be separated by whitespace e.g. "a - b".
+type Expression struct {
+	root        exprNode
+	metricNodes []*exprMetric
+	Metrics     []string // names of all metrics that are referenced by the expression
+}
+
+type exprNode interface {
+	eval(values []float32, now time.Time) float64
+}
+
+type exprNumber struct {
+	v float64
+}
+
+func (n *exprNumber) eval(values []float32, now time.Time) float64 {
+	return n.v
+}
+
+type exprMetric struct {
+	name  string
+	index int32
+}
+
+func (n *exprMetric) eval(values []float32, now time.Time) float64 {
+	return float64(values[n.index])
+}
+
+type exprNegate struct {
+	x exprNode
+}
+
+func (n *exprNegate) eval(values []float32, now time.Time) float64 {
+	return -n.x.eval(values, now)
+}
+
+type exprBinary struct {
+	op   byte
+	l, r exprNode
+}
+
+func (n *exprBinary) eval(values []float32, now time.Time) float64 {
+	var l = n.l.eval(values, now)
+	var r = n.r.eval(values, now)
+
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		return l / r
+	}
+}
+
+// exprChange implements rate and delta which both need the value and time of the last evaluation
+type exprChange struct {
+	perSecond bool
+	x         exprNode
+
+	initialized bool
+	last        float64
+	lastTime    time.Time
+}
+
+func (n *exprChange) eval(values []float32, now time.Time) float64 {
+	var v = n.x.eval(values, now)
+	var r = 0.0
+
+	if n.initialized {
+		r = v - n.last
+
+		if n.perSecond {
+			r /= now.Sub(n.lastTime).Seconds()
+		}
+	}
+
+	n.initialized = true
+	n.last = v
+	n.lastTime = now
+
+	return r
+}
+
+type exprFunction struct {
+	f    func(args []float64) float64
+	args []exprNode
+}
+
+func (n *exprFunction) eval(values []float32, now time.Time) float64 {
+	var args = make([]float64, len(n.args))
+
+	for i, a := range n.args {
+		args[i] = a.eval(values, now)
+	}
+
+	return n.f(args)
+}
+
+var exprFunctions = map[string]struct {
+	argc int
+	f    func(args []float64) float64
+}{
+	"abs": {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"max": {2, func(args []float64) float64 { return math.Max(args[0], args[1]) }},
+	"min": {2, func(args []float64) float64 { return math.Min(args[0], args[1]) }},
+}
+
+type exprParser struct {
+	s       string
+	pos     int
+	metrics []*exprMetric
+}
+
+// ParseExpression parses the expression of a derived metric.
+func ParseExpression(s string) (*Expression, error) {
+	var p = &exprParser{s: s}
+
+	root, err := p.parseSum()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("unexpected character '%c' at position %d", p.s[p.pos], p.pos)
+	}
+
+	var e = &Expression{root: root, metricNodes: p.metrics}
+	var seen = make(map[string]bool)
+
+	for _, m := range p.metrics {
+		if !seen[m.name] {
+			seen[m.name] = true
+
+			e.Metrics = append(e.Metrics, m.name)
+		}
+	}
+
+	return e, nil
+}
+
+// Bind resolves all referenced metric names of the expression to their indizes.
+func (e *Expression) Bind(indizes map[string]int32) error {
+	for _, m := range e.metricNodes {
+		i, ok := indizes[m.name]
+
+		if !ok {
+			return fmt.Errorf("unknown metric \"%s\"", m.name)
+		}
+
+		m.index = i
+	}
+
+	return nil
+}
+
+// Eval evaluates the expression with the given metric values.
+// Results which are not finite numbers, like divisions by zero, are returned as 0.
+func (e *Expression) Eval(values []float32, now time.Time) float32 {
+	var v = e.root.eval(values, now)
+
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0.0
+	}
+
+	return float32(v)
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+
+	if p.pos == len(p.s) {
+		return 0
+	}
+
+	return p.s[p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	l, err := p.parseProduct()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op = p.peek()
+
+		if op != '+' && op != '-' {
+			return l, nil
+		}
+
+		p.pos++
+
+		r, err := p.parseProduct()
+
+		if err != nil {
+			return nil, err
+		}
+
+		l = &exprBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	l, err := p.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op = p.peek()
+
+		if op != '*' && op != '/' {
+			return l, nil
+		}
+
+		p.pos++
+
+		r, err := p.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		l = &exprBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+
+		x, err := p.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &exprNegate{x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	var c = p.peek()
+
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+
+		x, err := p.parseSum()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ')' at position %d", p.pos)
+		}
+
+		p.pos++
+
+		return x, nil
+	case (c >= '0' && c <= '9') || c == '.':
+		var start = p.pos
+
+		for p.pos < len(p.s) && ((p.s[p.pos] >= '0' && p.s[p.pos] <= '9') || p.s[p.pos] == '.') {
+			p.pos++
+		}
+
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid number \"%s\" at position %d", p.s[start:p.pos], start)
+		}
+
+		return &exprNumber{v}, nil
+	case isExprNameChar(c):
+		var start = p.pos
+
+		for p.pos < len(p.s) && (isExprNameChar(p.s[p.pos]) || p.s[p.pos] == '.' || ((p.s[p.pos] == '-' || p.s[p.pos] == '/') && p.pos+1 < len(p.s) && isExprNameChar(p.s[p.pos+1]))) {
+			p.pos++
+		}
+
+		var name = p.s[start:p.pos]
+
+		if p.peek() != '(' {
+			var m = &exprMetric{name: name}
+
+			p.metrics = append(p.metrics, m)
+
+			return m, nil
+		}
+
+		p.pos++
+
+		var args []exprNode
+
+		for {
+			a, err := p.parseSum()
+
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, a)
+
+			if c := p.peek(); c == ',' {
+				p.pos++
+			} else if c == ')' {
+				p.pos++
+
+				break
+			} else {
+				return nil, fmt.Errorf("missing ')' at position %d", p.pos)
+			}
+		}
+
+		switch name {
+		case "delta", "rate":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("function \"%s\" needs exactly 1 argument", name)
+			}
+
+			return &exprChange{perSecond: name == "rate", x: args[0]}, nil
+		default:
+			f, ok := exprFunctions[name]
+
+			if !ok {
+				return nil, fmt.Errorf("unknown function \"%s\"", name)
+			} else if len(args) != f.argc {
+				return nil, fmt.Errorf("function \"%s\" needs exactly %d argument(s)", name, f.argc)
+			}
+
+			return &exprFunction{f: f.f, args: args}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unexpected character '%c' at position %d", c, p.pos)
+	}
+}
+
+func isExprNameChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
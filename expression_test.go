@@ -0,0 +1,130 @@
+package tirion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpressionEval(t *testing.T) {
+	var indizes = map[string]int32{
+		"a":               0,
+		"b":               1,
+		"zero":            2,
+		"proc.stat.utime": 3,
+		"db/queries":      4,
+		"cache-hits":      5,
+	}
+	var values = []float32{6, 3, 0, 2, 10, 4}
+
+	var tests = []struct {
+		expression string
+		result     float32
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"8 - 2 - 1", 5},
+		{"8 / 2 / 2", 2},
+		{"2 * 3 + 4 * 5", 26},
+		{"-a + b", -3},
+		{"-(a + b)", -9},
+		{"a - -b", 9},
+		{"a / b", 2},
+		{"a - b * 2", 0},
+		{"proc.stat.utime * 2", 4},
+		{"db/queries / 5", 2},
+		{"cache-hits - 1", 3},
+		{"cache-hits / 2", 2},
+		{"abs(b - a)", 3},
+		{"max(a, b) + min(a, b)", 9},
+		{"max(min(a, 10), b * 3)", 9},
+		{"1.5 * 2", 3},
+		{"a / zero", 0},
+		{"a / 0", 0},
+		{"zero / zero", 0},
+		{"-a / zero", 0},
+	}
+
+	for _, test := range tests {
+		e, err := ParseExpression(test.expression)
+
+		if err != nil {
+			t.Errorf("ParseExpression(%q) failed: %v", test.expression, err)
+
+			continue
+		}
+
+		if err := e.Bind(indizes); err != nil {
+			t.Errorf("Bind of %q failed: %v", test.expression, err)
+
+			continue
+		}
+
+		if r := e.Eval(values, time.Now()); r != test.result {
+			t.Errorf("Eval of %q = %v, want %v", test.expression, r, test.result)
+		}
+	}
+}
+
+func TestExpressionInvalid(t *testing.T) {
+	var tests = []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 + 2)",
+		"a b",
+		"1..2",
+		"unknown(a)",
+		"abs(a, b)",
+		"max(a)",
+		"rate(a, b)",
+		"min(a, b",
+		"a * * b",
+	}
+
+	for _, expression := range tests {
+		if _, err := ParseExpression(expression); err == nil {
+			t.Errorf("ParseExpression(%q) did not fail", expression)
+		}
+	}
+}
+
+func TestExpressionBind(t *testing.T) {
+	e, err := ParseExpression("a + b + a")
+
+	if err != nil {
+		t.Fatalf("ParseExpression failed: %v", err)
+	}
+
+	if len(e.Metrics) != 2 || e.Metrics[0] != "a" || e.Metrics[1] != "b" {
+		t.Errorf("Metrics = %v, want [a b]", e.Metrics)
+	}
+
+	if err := e.Bind(map[string]int32{"a": 0}); err == nil {
+		t.Errorf("Bind with an unknown metric did not fail")
+	}
+}
+
+func TestExpressionChange(t *testing.T) {
+	e, err := ParseExpression("rate(a) + delta(a)")
+
+	if err != nil {
+		t.Fatalf("ParseExpression failed: %v", err)
+	}
+
+	if err := e.Bind(map[string]int32{"a": 0}); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+
+	var now = time.Now()
+
+	if r := e.Eval([]float32{10}, now); r != 0 {
+		t.Errorf("first Eval = %v, want 0", r)
+	}
+	if r := e.Eval([]float32{30}, now.Add(2*time.Second)); r != 30 {
+		t.Errorf("second Eval = %v, want 30", r)
+	}
+	// no time passed, so the rate is a division by zero
+	if r := e.Eval([]float32{30}, now.Add(2*time.Second)); r != 0 {
+		t.Errorf("third Eval = %v, want 0", r)
+	}
+}
@@ -15,6 +15,32 @@ type MessageData struct {
 	Data []float32
 }
 
+// MessageMetrics contains the metrics which are added to a started run. Their values are appended to the data of further data messages.
+type MessageMetrics struct {
+	Message
+	Metrics []Metric
+}
+
+// MessageSeries contains all data of a series message.
+type MessageSeries struct {
+	Message
+	Series []SeriesValue
+}
+
+// SeriesValue contains the value of a dynamically named series of a metric.
+type SeriesValue struct {
+	Metric string
+	Series string
+	Value  float32
+}
+
+// MessageLog contains all data of a log message.
+type MessageLog struct {
+	Message
+	Stream string // stream of the program which printed the line e.g. "stdout" or "stderr"
+	Line   string
+}
+
 // MessageReturnInsert contains all data of the result of an Insert call.
 type MessageReturnInsert struct {
 	Error string
@@ -36,8 +62,21 @@ type MessageReturnTag struct {
 	Error string
 }
 
+// MessageSpan contains all data of a span message. The time of the message is the start of the span.
+type MessageSpan struct {
+	Message
+	Name       string // names of the span and its parents e.g. "request/parse"
+	Parent     string // names of the parent span or empty if the span has no parent
+	Stop       time.Time
+	Unfinished bool // states that the span was not ended by the program but by the end of the run
+}
+
 // MessageTag contains all data of tag message.
 type MessageTag struct {
 	Message
-	Tag string
+	Tag        string
+	Category   string
+	Severity   string
+	Attributes map[string]string
+	Duration   time.Duration
 }
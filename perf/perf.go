@@ -0,0 +1,213 @@
+package perf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	typeHardware = 0
+	typeSoftware = 1
+
+	hwCPUCycles    = 0
+	hwInstructions = 1
+	hwCacheMisses  = 3
+	hwBranchMisses = 5
+
+	swPageFaults      = 2
+	swContextSwitches = 3
+
+	attrFlagInherit       = 1 << 1
+	attrFlagExcludeKernel = 1 << 5
+	attrFlagExcludeHv     = 1 << 6
+
+	formatTotalTimeEnabled = 1 << 0
+	formatTotalTimeRunning = 1 << 1
+
+	flagFdCloexec = 1 << 3
+)
+
+var Indizes = map[string]int{
+	"perf.cycles":           0,
+	"perf.instructions":     1,
+	"perf.cache_misses":     2,
+	"perf.branch_misses":    3,
+	"perf.page_faults":      4,
+	"perf.context_switches": 5,
+}
+
+var events = []struct {
+	typ    uint32
+	config uint64
+}{
+	{typeHardware, hwCPUCycles},
+	{typeHardware, hwInstructions},
+	{typeHardware, hwCacheMisses},
+	{typeHardware, hwBranchMisses},
+	{typeSoftware, swPageFaults},
+	{typeSoftware, swContextSwitches},
+}
+
+// eventAttr is the first version of struct perf_event_attr (PERF_ATTR_SIZE_VER0)
+type eventAttr struct {
+	Type         uint32
+	Size         uint32
+	Config       uint64
+	SamplePeriod uint64
+	SampleType   uint64
+	ReadFormat   uint64
+	Flags        uint64
+	WakeupEvents uint32
+	BpType       uint32
+	Config1      uint64
+}
+
+// Counters holds the perf counters of a process.
+// Every thread which exists when the counters are opened gets its own counter, threads and children which are spawned afterwards are counted through inheritance.
+type Counters struct {
+	fds [][]int // counters of every event, one per thread
+
+	// Errors holds the reason for every requested counter that could not be opened
+	Errors map[string]error
+}
+
+// OpenCounters opens the perf counters with the given names for a process.
+// Counters that cannot be opened, for example because the hardware or a virtual machine does not support them, are reported in Errors and always read as 0.
+func OpenCounters(pid int, names []string) *Counters {
+	var c = &Counters{
+		fds:    make([][]int, len(events)),
+		Errors: make(map[string]error),
+	}
+
+	var tids = tasks(pid)
+
+	for _, name := range names {
+		i, ok := Indizes[name]
+
+		if !ok {
+			c.Errors[name] = fmt.Errorf("unknown perf counter")
+
+			continue
+		}
+
+		for _, tid := range tids {
+			fd, err := openCounter(tid, events[i].typ, events[i].config, 0)
+
+			if err == syscall.EACCES || err == syscall.EPERM {
+				// kernel events may not be counted by unprivileged users, see /proc/sys/kernel/perf_event_paranoid
+				fd, err = openCounter(tid, events[i].typ, events[i].config, attrFlagExcludeKernel|attrFlagExcludeHv)
+			}
+
+			if err == syscall.ESRCH && tid != pid {
+				// the thread exited in the meantime
+				continue
+			} else if err == syscall.ENOENT || err == syscall.EOPNOTSUPP {
+				c.Errors[name] = fmt.Errorf("event is not supported by the CPU or kernel")
+			} else if err != nil {
+				c.Errors[name] = err
+			}
+
+			if err != nil {
+				closeFds(c.fds[i])
+
+				c.fds[i] = nil
+
+				break
+			}
+
+			c.fds[i] = append(c.fds[i], fd)
+		}
+	}
+
+	return c
+}
+
+// tasks returns the TIDs of all threads of a process. The process itself is the first thread.
+func tasks(pid int) []int {
+	var tids = []int{pid}
+
+	files, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+
+	if err != nil {
+		return tids
+	}
+
+	for _, f := range files {
+		if tid, err := strconv.Atoi(f.Name()); err == nil && tid != pid {
+			tids = append(tids, tid)
+		}
+	}
+
+	return tids
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
+
+func openCounter(pid int, typ uint32, config uint64, flags uint64) (int, error) {
+	var attr = eventAttr{
+		Type:       typ,
+		Config:     config,
+		ReadFormat: formatTotalTimeEnabled | formatTotalTimeRunning,
+		Flags:      attrFlagInherit | flags,
+	}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+
+	fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN, uintptr(unsafe.Pointer(&attr)), uintptr(pid), ^uintptr(0), ^uintptr(0), flagFdCloexec, 0)
+
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(fd), nil
+}
+
+// ReadArray reads the current values of all counters.
+// Values are scaled if the kernel had to multiplex the counters.
+func (c *Counters) ReadArray() ([]string, error) {
+	var r = make([]string, len(c.fds))
+	var buf [24]byte
+
+	for i, fds := range c.fds {
+		var sum uint64
+
+		for _, fd := range fds {
+			n, err := syscall.Read(fd, buf[:])
+
+			if err != nil {
+				return nil, err
+			} else if n != len(buf) {
+				return nil, fmt.Errorf("short read of perf counter")
+			}
+
+			var value = *(*uint64)(unsafe.Pointer(&buf[0]))
+			var enabled = *(*uint64)(unsafe.Pointer(&buf[8]))
+			var running = *(*uint64)(unsafe.Pointer(&buf[16]))
+
+			if running != 0 && running < enabled {
+				value = uint64(float64(value) * float64(enabled) / float64(running))
+			}
+
+			sum += value
+		}
+
+		r[i] = strconv.FormatUint(sum, 10)
+	}
+
+	return r, nil
+}
+
+// Close closes all opened counters
+func (c *Counters) Close() {
+	for i, fds := range c.fds {
+		closeFds(fds)
+
+		c.fds[i] = nil
+	}
+}
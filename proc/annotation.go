@@ -0,0 +1,172 @@
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// Annotation holds the unit, scale and description of an external metric.
+// The scale converts the raw value of a metric into the given unit.
+type Annotation struct {
+	Unit        string
+	Scale       float64
+	Description string
+}
+
+// atClkTck is the auxiliary vector entry holding the frequency of times()
+const atClkTck = 17
+
+// PageSize is the size of a memory page in bytes
+var PageSize = os.Getpagesize()
+
+// ClockTicks is the number of clock ticks per second (CLK_TCK)
+var ClockTicks = readClockTicks()
+
+var pageScale = float64(PageSize)
+var tickScale = 1.0 / float64(ClockTicks)
+
+var Annotations = map[string]Annotation{
+	"proc.all.rssize": {"B", 1024.0, "Accumulated resident set size of all processes"},
+	"proc.all.vsize":  {"B", 1024.0, "Accumulated virtual memory size of all processes"},
+
+	"proc.io.rchar":                 {"B", 1.0, "Bytes read via read syscalls"},
+	"proc.io.wchar":                 {"B", 1.0, "Bytes written via write syscalls"},
+	"proc.io.syscr":                 {"syscalls", 1.0, "Read syscalls"},
+	"proc.io.syscw":                 {"syscalls", 1.0, "Write syscalls"},
+	"proc.io.read_bytes":            {"B", 1.0, "Bytes fetched from the storage layer"},
+	"proc.io.write_bytes":           {"B", 1.0, "Bytes sent to the storage layer"},
+	"proc.io.cancelled_write_bytes": {"B", 1.0, "Bytes of cancelled writes"},
+
+	"proc.stat.minflt":                {"faults", 1.0, "Minor page faults"},
+	"proc.stat.cminflt":               {"faults", 1.0, "Minor page faults of waited-for children"},
+	"proc.stat.majflt":                {"faults", 1.0, "Major page faults"},
+	"proc.stat.cmajflt":               {"faults", 1.0, "Major page faults of waited-for children"},
+	"proc.stat.utime":                 {"s", tickScale, "Time scheduled in user mode"},
+	"proc.stat.stime":                 {"s", tickScale, "Time scheduled in kernel mode"},
+	"proc.stat.cutime":                {"s", tickScale, "Time waited-for children were scheduled in user mode"},
+	"proc.stat.cstime":                {"s", tickScale, "Time waited-for children were scheduled in kernel mode"},
+	"proc.stat.num_threads":           {"threads", 1.0, "Number of threads"},
+	"proc.stat.starttime":             {"s", tickScale, "Time the process started after system boot"},
+	"proc.stat.vsize":                 {"B", 1.0, "Virtual memory size"},
+	"proc.stat.rss":                   {"B", pageScale, "Resident set size"},
+	"proc.stat.rsslim":                {"B", 1.0, "Soft limit of the resident set size"},
+	"proc.stat.delayacct_blkio_ticks": {"s", tickScale, "Aggregated block I/O delays"},
+	"proc.stat.guest_time":            {"s", tickScale, "Time spent running a virtual CPU for a guest"},
+	"proc.stat.cguest_time":           {"s", tickScale, "Guest time of waited-for children"},
+
+	"proc.status.vm_peak":                    {"B", 1024.0, "Peak virtual memory size"},
+	"proc.status.vm_size":                    {"B", 1024.0, "Virtual memory size"},
+	"proc.status.vm_hwm":                     {"B", 1024.0, "Peak resident set size"},
+	"proc.status.vm_rss":                     {"B", 1024.0, "Resident set size"},
+	"proc.status.vm_swap":                    {"B", 1024.0, "Swapped-out virtual memory size"},
+	"proc.status.voluntary_ctxt_switches":    {"switches", 1.0, "Voluntary context switches"},
+	"proc.status.nonvoluntary_ctxt_switches": {"switches", 1.0, "Involuntary context switches"},
+
+	"proc.smaps_rollup.rss":           {"B", 1024.0, "Resident set size"},
+	"proc.smaps_rollup.pss":           {"B", 1024.0, "Proportional set size"},
+	"proc.smaps_rollup.shared_clean":  {"B", 1024.0, "Clean shared pages"},
+	"proc.smaps_rollup.shared_dirty":  {"B", 1024.0, "Dirty shared pages"},
+	"proc.smaps_rollup.private_clean": {"B", 1024.0, "Clean private pages"},
+	"proc.smaps_rollup.private_dirty": {"B", 1024.0, "Dirty private pages"},
+	"proc.smaps_rollup.swap":          {"B", 1024.0, "Swapped-out anonymous memory"},
+	"proc.smaps_rollup.swap_pss":      {"B", 1024.0, "Proportional swapped-out memory"},
+
+	"proc.sched.run_time":   {"s", 1e-9, "Time spent on the CPU"},
+	"proc.sched.run_delay":  {"s", 1e-9, "Time spent waiting on a runqueue"},
+	"proc.sched.timeslices": {"timeslices", 1.0, "Timeslices run on the CPU"},
+	"proc.sched.wait_sum":   {"s", 1e-3, "Accumulated wait time (needs kernel schedule statistics)"},
+
+	"proc.children.rss":   {"B", pageScale, "Resident set size"},
+	"proc.children.utime": {"s", tickScale, "Time scheduled in user mode"},
+	"proc.children.stime": {"s", tickScale, "Time scheduled in kernel mode"},
+
+	"proc.fd.count": {"fds", 1.0, "Open file descriptors"},
+
+	"proc.task.utime":     {"s", tickScale, "Time the thread was scheduled in user mode"},
+	"proc.task.stime":     {"s", tickScale, "Time the thread was scheduled in kernel mode"},
+	"proc.task.state":     {"threads", 1.0, "Running threads"},
+	"proc.task.processor": {"", 1.0, "CPU the thread was last executed on"},
+
+	"sys.cpu.busy":   {"%", 1.0, "CPU utilisation"},
+	"sys.cpu.user":   {"%", 1.0, "CPU utilisation in user mode"},
+	"sys.cpu.system": {"%", 1.0, "CPU utilisation in kernel mode including interrupts"},
+	"sys.cpu.iowait": {"%", 1.0, "CPU time waiting for I/O"},
+	"sys.cpu.steal":  {"%", 1.0, "CPU time stolen by other virtual machines"},
+
+	"sys.meminfo.mem_total":     {"B", 1024.0, "Total usable memory"},
+	"sys.meminfo.mem_free":      {"B", 1024.0, "Free memory"},
+	"sys.meminfo.mem_available": {"B", 1024.0, "Memory available for starting new applications"},
+	"sys.meminfo.buffers":       {"B", 1024.0, "Memory of raw disk blocks"},
+	"sys.meminfo.cached":        {"B", 1024.0, "Page cache"},
+	"sys.meminfo.dirty":         {"B", 1024.0, "Memory waiting to be written back to disk"},
+	"sys.meminfo.writeback":     {"B", 1024.0, "Memory actively being written back to disk"},
+	"sys.meminfo.swap_free":     {"B", 1024.0, "Free swap space"},
+
+	"sys.loadavg.load1":   {"", 1.0, "Load average of the last minute"},
+	"sys.loadavg.load5":   {"", 1.0, "Load average of the last 5 minutes"},
+	"sys.loadavg.load15":  {"", 1.0, "Load average of the last 15 minutes"},
+	"sys.loadavg.running": {"tasks", 1.0, "Runnable tasks"},
+	"sys.loadavg.total":   {"tasks", 1.0, "Existing tasks"},
+
+	"sys.diskstats.reads":         {"reads", 1.0, "Completed reads"},
+	"sys.diskstats.read_sectors":  {"B", 512.0, "Read sectors"},
+	"sys.diskstats.writes":        {"writes", 1.0, "Completed writes"},
+	"sys.diskstats.write_sectors": {"B", 512.0, "Written sectors"},
+	"sys.diskstats.in_progress":   {"I/Os", 1.0, "I/Os currently in progress"},
+	"sys.diskstats.io_time":       {"s", 1e-3, "Time spent doing I/Os"},
+
+	"sys.netdev.rx_bytes":   {"B", 1.0, "Received bytes"},
+	"sys.netdev.rx_packets": {"packets", 1.0, "Received packets"},
+	"sys.netdev.rx_errors":  {"errors", 1.0, "Receive errors"},
+	"sys.netdev.tx_bytes":   {"B", 1.0, "Transmitted bytes"},
+	"sys.netdev.tx_packets": {"packets", 1.0, "Transmitted packets"},
+	"sys.netdev.tx_errors":  {"errors", 1.0, "Transmit errors"},
+
+	"perf.cycles":           {"cycles", 1.0, "CPU cycles"},
+	"perf.instructions":     {"instructions", 1.0, "Retired instructions"},
+	"perf.cache_misses":     {"misses", 1.0, "Last level cache misses"},
+	"perf.branch_misses":    {"misses", 1.0, "Mispredicted branches"},
+	"perf.page_faults":      {"faults", 1.0, "Page faults"},
+	"perf.context_switches": {"switches", 1.0, "Context switches"},
+
+	"proc.statm.size":     {"B", pageScale, "Total program size"},
+	"proc.statm.resident": {"B", pageScale, "Resident set size"},
+	"proc.statm.share":    {"B", pageScale, "Resident shared pages"},
+	"proc.statm.text":     {"B", pageScale, "Text (code)"},
+	"proc.statm.lib":      {"B", pageScale, "Library (unused since Linux 2.6)"},
+	"proc.statm.data":     {"B", pageScale, "Data and stack"},
+	"proc.statm.dt":       {"B", pageScale, "Dirty pages (unused since Linux 2.6)"},
+}
+
+func init() {
+	for m := range PressureIndizes {
+		if strings.HasSuffix(m, "_total") {
+			Annotations[m] = Annotation{"s", 1e-6, "Accumulated stall time"}
+		} else {
+			Annotations[m] = Annotation{"%", 1.0, "Share of time some or all tasks were stalled"}
+		}
+	}
+}
+
+// readClockTicks reads CLK_TCK from the auxiliary vector of the current process which is the same as sysconf(_SC_CLK_TCK)
+func readClockTicks() int {
+	auxv, err := ioutil.ReadFile("/proc/self/auxv")
+
+	if err == nil {
+		var w = int(unsafe.Sizeof(uintptr(0)))
+
+		for i := 0; i+2*w <= len(auxv); i += 2 * w {
+			var k = *(*uintptr)(unsafe.Pointer(&auxv[i]))
+			var v = *(*uintptr)(unsafe.Pointer(&auxv[i+w]))
+
+			if k == atClkTck && v != 0 {
+				return int(v)
+			}
+		}
+	}
+
+	// USER_HZ is 100 on nearly every Linux system
+	return 100
+}
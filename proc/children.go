@@ -0,0 +1,109 @@
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ChildrenIndizes = map[string]int{
+	"proc.children.rss":   0,
+	"proc.children.utime": 1,
+	"proc.children.stime": 2,
+}
+
+// ReadChildrenArrays reads the stat files of the process with the given PID and all its descendants and maps the series name of each process to its metrics.
+func ReadChildrenArrays(pid int) (map[string][]string, error) {
+	processes, err := ReadProcessTree(pid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pChildren = make(map[string][]string, len(processes))
+
+	for _, p := range processes {
+		pChildren[p.Name()] = []string{strconv.Itoa(p.Rss), strconv.Itoa(p.Utime), strconv.Itoa(p.Stime)}
+	}
+
+	return pChildren, nil
+}
+
+// ReadProcessTree returns the stat data of the process with the given PID and of all its descendants.
+// The processes are ordered by their distance to the given process.
+func ReadProcessTree(pid int) ([]*Task, error) {
+	all, err := ReadProcesses()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var root *Task
+	var children = make(map[int][]*Task)
+
+	for _, p := range all {
+		if p.Tid == pid {
+			root = p
+		}
+
+		children[p.Ppid] = append(children[p.Ppid], p)
+	}
+
+	if root == nil {
+		return nil, os.ErrNotExist
+	}
+
+	var processes = []*Task{root}
+
+	for i := 0; i < len(processes); i++ {
+		processes = append(processes, children[processes[i].Tid]...)
+	}
+
+	return processes, nil
+}
+
+// ReadProcesses returns the stat data of all processes of the system.
+func ReadProcesses() ([]*Task, error) {
+	d, err := os.Open("/proc")
+
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := d.Readdirnames(-1)
+	d.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []*Task
+
+	for _, name := range names {
+		if _, err := strconv.Atoi(name); err != nil {
+			continue
+		}
+
+		pStatFile, err := ioutil.ReadFile("/proc/" + name + "/stat")
+
+		if err != nil {
+			// the process terminated in the meantime
+			if os.IsNotExist(err) || strings.HasSuffix(err.Error(), "no such process") {
+				continue
+			}
+
+			return nil, err
+		}
+
+		p, err := ParseTask(string(pStatFile))
+
+		if err != nil {
+			return nil, err
+		}
+
+		processes = append(processes, p)
+	}
+
+	return processes, nil
+}
@@ -0,0 +1,39 @@
+package proc
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+var DiskstatsIndizes = map[string]int{
+	"sys.diskstats.reads":         0,
+	"sys.diskstats.read_sectors":  1,
+	"sys.diskstats.writes":        2,
+	"sys.diskstats.write_sectors": 3,
+	"sys.diskstats.in_progress":   4,
+	"sys.diskstats.io_time":       5,
+}
+
+// ReadDiskstatsArrays reads the given diskstats file and maps every device to its counters.
+// Loop and RAM devices are left out.
+func ReadDiskstatsArrays(filename string) (map[string][]string, error) {
+	pDiskstatsFile, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pDiskstats = make(map[string][]string)
+
+	for _, l := range strings.Split(string(pDiskstatsFile), "\n") {
+		var f = strings.Fields(l)
+
+		if len(f) < 13 || strings.HasPrefix(f[2], "loop") || strings.HasPrefix(f[2], "ram") {
+			continue
+		}
+
+		pDiskstats[f[2]] = []string{f[3], f[5], f[7], f[9], f[11], f[12]}
+	}
+
+	return pDiskstats, nil
+}
@@ -0,0 +1,39 @@
+package proc
+
+import (
+	"os"
+	"strconv"
+)
+
+var FdIndizes = map[string]int{
+	"proc.fd.count": 0,
+}
+
+func ReadFdArray(dirname string) ([]string, error) {
+	count, err := ReadFdCount(dirname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(count)}, nil
+}
+
+// ReadFdCount returns the number of open file descriptors of the given fd folder of a process
+func ReadFdCount(dirname string) (int, error) {
+	d, err := os.Open(dirname)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(names), nil
+}
@@ -0,0 +1,66 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReadPidFile reads the PID of a PID file and checks that the process exists.
+func ReadPidFile(filename string) (int, error) {
+	pidFile, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidFile)))
+
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("cannot parse PID file %s", filename)
+	}
+
+	if !ProcessExists(pid) {
+		return 0, fmt.Errorf("process %d of PID file %s does not exist", pid, filename)
+	}
+
+	return pid, nil
+}
+
+// ProcessExists states if a process exists and is not a zombie.
+// Zombies of processes which are not children of the agent might never be reaped.
+func ProcessExists(pid int) bool {
+	pStatFile, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+
+	if err != nil {
+		return false
+	}
+
+	p, err := ParseTask(string(pStatFile))
+
+	return err == nil && p.State != 'Z' && p.State != 'X'
+}
+
+// FindPidsByName returns the ascending PIDs of all processes whose command name (comm) matches the given regular expression.
+func FindPidsByName(re *regexp.Regexp) ([]int, error) {
+	processes, err := ReadProcesses()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+
+	for _, p := range processes {
+		if re.MatchString(p.Comm) {
+			pids = append(pids, p.Tid)
+		}
+	}
+
+	sort.Ints(pids)
+
+	return pids, nil
+}
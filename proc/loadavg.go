@@ -0,0 +1,33 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var LoadavgIndizes = map[string]int{
+	"sys.loadavg.load1":   0,
+	"sys.loadavg.load5":   1,
+	"sys.loadavg.load15":  2,
+	"sys.loadavg.running": 3,
+	"sys.loadavg.total":   4,
+}
+
+func ReadLoadavgArray(filename string) ([]string, error) {
+	pLoadavgFile, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f = strings.Fields(string(pLoadavgFile))
+
+	if len(f) < 4 || !strings.Contains(f[3], "/") {
+		return nil, fmt.Errorf("cannot parse loadavg: %s", pLoadavgFile)
+	}
+
+	var entities = strings.SplitN(f[3], "/", 2)
+
+	return []string{f[0], f[1], f[2], entities[0], entities[1]}, nil
+}
@@ -0,0 +1,27 @@
+package proc
+
+var MeminfoIndizes = map[string]int{
+	"sys.meminfo.mem_total":     0,
+	"sys.meminfo.mem_free":      1,
+	"sys.meminfo.mem_available": 2,
+	"sys.meminfo.buffers":       3,
+	"sys.meminfo.cached":        4,
+	"sys.meminfo.dirty":         5,
+	"sys.meminfo.writeback":     6,
+	"sys.meminfo.swap_free":     7,
+}
+
+var meminfoKeys = []string{
+	"MemTotal",
+	"MemFree",
+	"MemAvailable",
+	"Buffers",
+	"Cached",
+	"Dirty",
+	"Writeback",
+	"SwapFree",
+}
+
+func ReadMeminfoArray(filename string) ([]string, error) {
+	return readKeyValueArray(filename, meminfoKeys)
+}
@@ -0,0 +1,44 @@
+package proc
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+var NetdevIndizes = map[string]int{
+	"sys.netdev.rx_bytes":   0,
+	"sys.netdev.rx_packets": 1,
+	"sys.netdev.rx_errors":  2,
+	"sys.netdev.tx_bytes":   3,
+	"sys.netdev.tx_packets": 4,
+	"sys.netdev.tx_errors":  5,
+}
+
+// ReadNetdevArrays reads the given net/dev file and maps every network interface to its counters.
+func ReadNetdevArrays(filename string) (map[string][]string, error) {
+	pNetdevFile, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pNetdev = make(map[string][]string)
+
+	for _, l := range strings.Split(string(pNetdevFile), "\n") {
+		var i = strings.Index(l, ":")
+
+		if i == -1 {
+			continue
+		}
+
+		var f = strings.Fields(l[i+1:])
+
+		if len(f) < 11 {
+			continue
+		}
+
+		pNetdev[strings.TrimSpace(l[:i])] = []string{f[0], f[1], f[2], f[8], f[9], f[10]}
+	}
+
+	return pNetdev, nil
+}
@@ -0,0 +1,79 @@
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var pressureResources = []string{"cpu", "memory", "io"}
+var pressureValues = []string{"avg10", "avg60", "avg300", "total"}
+
+// PressureIndizes holds the metrics sys.pressure.<resource>.<some|full>_<avg10|avg60|avg300|total>
+var PressureIndizes = pressureIndizes()
+
+func pressureIndizes() map[string]int {
+	var indizes = make(map[string]int)
+
+	for _, r := range pressureResources {
+		for _, k := range []string{"some", "full"} {
+			for _, v := range pressureValues {
+				indizes["sys.pressure."+r+"."+k+"_"+v] = len(indizes)
+			}
+		}
+	}
+
+	return indizes
+}
+
+// ReadPressureArray reads the PSI files of the given pressure folder.
+// Resources which are not supported by the kernel have the value "0".
+func ReadPressureArray(dirname string) ([]string, error) {
+	var values = make([]string, len(PressureIndizes))
+
+	for i := range values {
+		values[i] = "0"
+	}
+
+	for ri, r := range pressureResources {
+		pPressureFile, err := ioutil.ReadFile(dirname + r)
+
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		for _, l := range strings.Split(string(pPressureFile), "\n") {
+			var f = strings.Fields(l)
+
+			if len(f) == 0 || (f[0] != "some" && f[0] != "full") {
+				continue
+			}
+
+			var offset = ri * 2 * len(pressureValues)
+
+			if f[0] == "full" {
+				offset += len(pressureValues)
+			}
+
+			for _, kv := range f[1:] {
+				var p = strings.SplitN(kv, "=", 2)
+
+				if len(p) != 2 {
+					continue
+				}
+
+				for vi, v := range pressureValues {
+					if p[0] == v {
+						values[offset+vi] = p[1]
+					}
+				}
+			}
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,69 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type Sched struct {
+	RunTime    int64
+	RunDelay   int64
+	Timeslices int64
+	WaitSum    float64
+}
+
+var SchedIndizes = map[string]int{
+	"proc.sched.run_time":   0,
+	"proc.sched.run_delay":  1,
+	"proc.sched.timeslices": 2,
+	"proc.sched.wait_sum":   3,
+}
+
+// ReadSchedArray reads the schedstat and sched files of the given PID folder.
+// The sched file is optional as wait_sum is only available if the kernel collects schedule statistics.
+func ReadSchedArray(pidFolder string) ([]string, error) {
+	pSchedstatFile, err := ioutil.ReadFile(pidFolder + "schedstat")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sched = strings.Fields(string(pSchedstatFile))
+
+	if len(sched) < 3 {
+		return nil, fmt.Errorf("cannot parse schedstat: %s", pSchedstatFile)
+	}
+
+	sched = append(sched[:3], "0")
+
+	if waitSum, err := readKeyValueArray(pidFolder+"sched", []string{"se.statistics.wait_sum", "se.wait_sum", "wait_sum"}); err == nil {
+		for _, v := range waitSum {
+			if v != "0" {
+				sched[3] = v
+
+				break
+			}
+		}
+	}
+
+	return sched, nil
+}
+
+func ReadSched(pidFolder string) (*Sched, error) {
+	pSchedRaw, err := ReadSchedArray(pidFolder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pSched = new(Sched)
+
+	pSched.RunTime, _ = strconv.ParseInt(pSchedRaw[0], 10, 64)
+	pSched.RunDelay, _ = strconv.ParseInt(pSchedRaw[1], 10, 64)
+	pSched.Timeslices, _ = strconv.ParseInt(pSchedRaw[2], 10, 64)
+	pSched.WaitSum, _ = strconv.ParseFloat(pSchedRaw[3], 64)
+
+	return pSched, nil
+}
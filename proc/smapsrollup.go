@@ -0,0 +1,67 @@
+package proc
+
+import (
+	"strconv"
+)
+
+type SmapsRollup struct {
+	Rss          int64
+	Pss          int64
+	SharedClean  int64
+	SharedDirty  int64
+	PrivateClean int64
+	PrivateDirty int64
+	Swap         int64
+	SwapPss      int64
+}
+
+var SmapsRollupIndizes = map[string]int{
+	"proc.smaps_rollup.rss":           0,
+	"proc.smaps_rollup.pss":           1,
+	"proc.smaps_rollup.shared_clean":  2,
+	"proc.smaps_rollup.shared_dirty":  3,
+	"proc.smaps_rollup.private_clean": 4,
+	"proc.smaps_rollup.private_dirty": 5,
+	"proc.smaps_rollup.swap":          6,
+	"proc.smaps_rollup.swap_pss":      7,
+}
+
+var smapsRollupKeys = []string{
+	"Rss",
+	"Pss",
+	"Shared_Clean",
+	"Shared_Dirty",
+	"Private_Clean",
+	"Private_Dirty",
+	"Swap",
+	"SwapPss",
+}
+
+func ReadSmapsRollupArray(filename string) ([]string, error) {
+	return readKeyValueArray(filename, smapsRollupKeys)
+}
+
+func ReadSmapsRollup(filename string) (*SmapsRollup, error) {
+	pSmapsRollupRaw, err := ReadSmapsRollupArray(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var r = make([]int64, len(pSmapsRollupRaw))
+
+	for i := range r {
+		r[i], _ = strconv.ParseInt(pSmapsRollupRaw[i], 10, 64)
+	}
+
+	return &SmapsRollup{
+		Rss:          r[0],
+		Pss:          r[1],
+		SharedClean:  r[2],
+		SharedDirty:  r[3],
+		PrivateClean: r[4],
+		PrivateDirty: r[5],
+		Swap:         r[6],
+		SwapPss:      r[7],
+	}, nil
+}
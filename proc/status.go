@@ -0,0 +1,97 @@
+package proc
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type Status struct {
+	VmPeak                   int64
+	VmSize                   int64
+	VmHWM                    int64
+	VmRSS                    int64
+	VmSwap                   int64
+	VoluntaryCtxtSwitches    int64
+	NonvoluntaryCtxtSwitches int64
+}
+
+var StatusIndizes = map[string]int{
+	"proc.status.vm_peak":                    0,
+	"proc.status.vm_size":                    1,
+	"proc.status.vm_hwm":                     2,
+	"proc.status.vm_rss":                     3,
+	"proc.status.vm_swap":                    4,
+	"proc.status.voluntary_ctxt_switches":    5,
+	"proc.status.nonvoluntary_ctxt_switches": 6,
+}
+
+var statusKeys = []string{
+	"VmPeak",
+	"VmSize",
+	"VmHWM",
+	"VmRSS",
+	"VmSwap",
+	"voluntary_ctxt_switches",
+	"nonvoluntary_ctxt_switches",
+}
+
+// readKeyValueArray reads a file with "key: value" lines and returns the values of the given keys in their order.
+// Units like "kB" are stripped and missing keys have the value "0".
+func readKeyValueArray(filename string, keys []string) ([]string, error) {
+	file, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var indizes = make(map[string]int, len(keys))
+	var values = make([]string, len(keys))
+
+	for i, k := range keys {
+		indizes[k] = i
+		values[i] = "0"
+	}
+
+	for _, l := range strings.Split(string(file), "\n") {
+		var kv = strings.SplitN(l, ":", 2)
+
+		if len(kv) != 2 {
+			continue
+		}
+
+		if i, ok := indizes[strings.TrimSpace(kv[0])]; ok {
+			values[i] = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(kv[1]), "kB"))
+		}
+	}
+
+	return values, nil
+}
+
+func ReadStatusArray(filename string) ([]string, error) {
+	return readKeyValueArray(filename, statusKeys)
+}
+
+func ReadStatus(filename string) (*Status, error) {
+	pStatusRaw, err := ReadStatusArray(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var r = make([]int64, len(pStatusRaw))
+
+	for i := range r {
+		r[i], _ = strconv.ParseInt(pStatusRaw[i], 10, 64)
+	}
+
+	return &Status{
+		VmPeak:                   r[0],
+		VmSize:                   r[1],
+		VmHWM:                    r[2],
+		VmRSS:                    r[3],
+		VmSwap:                   r[4],
+		VoluntaryCtxtSwitches:    r[5],
+		NonvoluntaryCtxtSwitches: r[6],
+	}, nil
+}
@@ -0,0 +1,77 @@
+package proc
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var SysCPUIndizes = map[string]int{
+	"sys.cpu.busy":   0,
+	"sys.cpu.user":   1,
+	"sys.cpu.system": 2,
+	"sys.cpu.iowait": 3,
+	"sys.cpu.steal":  4,
+}
+
+// SysCPU calculates the CPU utilisation of every core between two reads of /proc/stat
+type SysCPU struct {
+	last map[string][]int64
+}
+
+// ReadArrays reads the given /proc/stat file and maps every core to its utilisation in percent since the last read.
+// The first read has a utilisation of 0 for all cores.
+func (s *SysCPU) ReadArrays(filename string) (map[string][]string, error) {
+	pStatFile, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var current = make(map[string][]int64)
+	var pCPU = make(map[string][]string)
+
+	for _, l := range strings.Split(string(pStatFile), "\n") {
+		var f = strings.Fields(l)
+
+		// the accumulated "cpu" line is left out as the sum of all cores is recorded anyway
+		if len(f) < 9 || !strings.HasPrefix(f[0], "cpu") || f[0] == "cpu" {
+			continue
+		}
+
+		// user nice system idle iowait irq softirq steal
+		var t = make([]int64, 8)
+
+		for i := range t {
+			t[i], _ = strconv.ParseInt(f[i+1], 10, 64)
+		}
+
+		var total = t[0] + t[1] + t[2] + t[3] + t[4] + t[5] + t[6] + t[7]
+
+		// total user system iowait steal idle
+		current[f[0]] = []int64{total, t[0] + t[1], t[2] + t[5] + t[6], t[4], t[7], t[3]}
+
+		var values = []string{"0", "0", "0", "0", "0"}
+
+		if last, ok := s.last[f[0]]; ok && current[f[0]][0] > last[0] {
+			var c = current[f[0]]
+			var d = float64(c[0] - last[0])
+
+			var percent = func(i int) string {
+				return strconv.FormatFloat(float64(c[i]-last[i])/d*100.0, 'f', 2, 64)
+			}
+
+			values[0] = strconv.FormatFloat((d-float64(c[5]-last[5])-float64(c[3]-last[3]))/d*100.0, 'f', 2, 64)
+			values[1] = percent(1)
+			values[2] = percent(2)
+			values[3] = percent(3)
+			values[4] = percent(4)
+		}
+
+		pCPU[f[0]] = values
+	}
+
+	s.last = current
+
+	return pCPU, nil
+}
@@ -0,0 +1,128 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Task holds the stat data of a thread or a process
+type Task struct {
+	Tid       int
+	Comm      string
+	State     byte
+	Ppid      int
+	Utime     int
+	Stime     int
+	Rss       int
+	Processor int
+}
+
+var TaskIndizes = map[string]int{
+	"proc.task.utime":     0,
+	"proc.task.stime":     1,
+	"proc.task.state":     2,
+	"proc.task.processor": 3,
+}
+
+// Name returns the series name of the task which consists of its TID and comm
+func (t *Task) Name() string {
+	return fmt.Sprintf("%d (%s)", t.Tid, t.Comm)
+}
+
+// ReadTaskArrays reads the stat files of all threads of the given PID folder and maps the series name of each thread to its metrics.
+// The state of a thread is 1 if it is running and 0 otherwise.
+func ReadTaskArrays(pidFolder string) (map[string][]string, error) {
+	tasks, err := ReadTasks(pidFolder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pTasks = make(map[string][]string, len(tasks))
+
+	for _, t := range tasks {
+		var running = "0"
+
+		if t.State == 'R' {
+			running = "1"
+		}
+
+		pTasks[t.Name()] = []string{strconv.Itoa(t.Utime), strconv.Itoa(t.Stime), running, strconv.Itoa(t.Processor)}
+	}
+
+	return pTasks, nil
+}
+
+func ReadTasks(pidFolder string) ([]*Task, error) {
+	d, err := os.Open(pidFolder + "task")
+
+	if err != nil {
+		return nil, err
+	}
+
+	tids, err := d.Readdirnames(-1)
+	d.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks = make([]*Task, 0, len(tids))
+
+	for _, tid := range tids {
+		pTaskFile, err := ioutil.ReadFile(pidFolder + "task/" + tid + "/stat")
+
+		if err != nil {
+			// the thread terminated in the meantime
+			if os.IsNotExist(err) || strings.HasSuffix(err.Error(), "no such process") {
+				continue
+			}
+
+			return nil, err
+		}
+
+		t, err := ParseTask(string(pTaskFile))
+
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	return tasks, nil
+}
+
+// ParseTask parses the stat file of a thread.
+// Other than ParseStat it handles comms with spaces and parentheses.
+func ParseTask(stat string) (*Task, error) {
+	var start = strings.Index(stat, "(")
+	var end = strings.LastIndex(stat, ")")
+
+	if start == -1 || end < start {
+		return nil, fmt.Errorf("cannot parse task stat: %s", stat)
+	}
+
+	var pTaskRaw = strings.Fields(stat[end+1:])
+
+	// fields after the comm start with the state which is field 2 of ParseStat
+	if len(pTaskRaw) < 37 {
+		return nil, fmt.Errorf("cannot parse task stat: %s", stat)
+	}
+
+	var pTask = new(Task)
+
+	pTask.Tid, _ = strconv.Atoi(strings.TrimSpace(stat[:start]))
+	pTask.Comm = stat[start+1 : end]
+	pTask.State = pTaskRaw[0][0]
+	pTask.Ppid, _ = strconv.Atoi(pTaskRaw[1])
+	pTask.Utime, _ = strconv.Atoi(pTaskRaw[11])
+	pTask.Stime, _ = strconv.Atoi(pTaskRaw[12])
+	pTask.Rss, _ = strconv.Atoi(pTaskRaw[21])
+	pTask.Processor, _ = strconv.Atoi(pTaskRaw[36])
+
+	return pTask, nil
+}
@@ -0,0 +1,199 @@
+package tirion
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricSource is a source of external metrics which are fetched by the agent itself at every interval.
+type MetricSource interface {
+	// Prefix returns the prefix of all metric names served by the source e.g. "proc.io."
+	Prefix() string
+	// Names returns all metric names served by the source. nil means that every name with the prefix is served.
+	Names() []string
+	// Series states if the metrics of the source are recorded as series
+	Series() bool
+
+	// Open prepares the source to read the given metrics of the program with the given PID.
+	// An error of Open is not fatal. The agent reports it and metrics the source cannot serve are read as 0.
+	Open(pid int32, metrics []Metric) error
+	// Read reads the current values of all opened metrics.
+	// An error of Read is not fatal. The agent reports it and keeps the values of the last successful read unless Read returns data together with the error.
+	Read() (*SourceData, error)
+	// Close releases all resources of the source
+	Close() error
+}
+
+// LineReader is implemented by metric sources which read the output of the program.
+// ReadLine is called for every line the program prints and can be called concurrently to Read.
+type LineReader interface {
+	ReadLine(stream string, line string)
+}
+
+// SourceData holds the values which were read by a MetricSource.
+type SourceData struct {
+	Values []float32            // values of the opened metrics in the order they were opened. series sources hold the sum of all series
+	Series map[string][]float32 // values of the opened metrics for each series name
+	Tags   []string             // events that should be tagged
+}
+
+var metricSources []metricSourceFactory
+
+type metricSourceFactory struct {
+	prototype MetricSource
+	names     map[string]bool
+	new       func() MetricSource
+}
+
+// RegisterMetricSource registers a constructor for a MetricSource.
+// The agent creates a new source for every run. Creating a source must therefore not allocate resources, this is done by Open.
+func RegisterMetricSource(new func() MetricSource) {
+	var s = new()
+	var f = metricSourceFactory{
+		prototype: s,
+		new:       new,
+	}
+
+	if names := s.Names(); names != nil {
+		f.names = make(map[string]bool)
+
+		for _, n := range names {
+			f.names[n] = true
+		}
+	}
+
+	metricSources = append(metricSources, f)
+}
+
+// lookupMetricSource returns the registered source with the longest prefix matching the metric name
+func lookupMetricSource(name string) (*metricSourceFactory, bool) {
+	var found *metricSourceFactory
+
+	for i, f := range metricSources {
+		if strings.HasPrefix(name, f.prototype.Prefix()) && (found == nil || len(f.prototype.Prefix()) > len(found.prototype.Prefix())) {
+			found = &metricSources[i]
+		}
+	}
+
+	return found, found != nil
+}
+
+// isSourceNamespace states if a metric name lies in the namespace of a registered source e.g. "proc." even if no source offers the metric.
+// Such names are reserved for external metrics, so a misspelled external metric is not mistaken for an internal metric.
+func isSourceNamespace(name string) bool {
+	for _, f := range metricSources {
+		var prefix = f.prototype.Prefix()
+
+		if i := strings.Index(prefix, "."); i != -1 && strings.HasPrefix(name, prefix[:i+1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// arraySource is a metric source for metric groups of the proc package which are read as one array of values per fetch
+type arraySource struct {
+	prefix    string
+	indizes   map[string]int
+	read      func(pid int32) ([]string, error)
+	readItems func(pid int32) (map[string][]string, error)
+
+	pid  int32
+	keys []int
+}
+
+func newArraySource(prefix string, indizes map[string]int, read func(pid int32) ([]string, error)) *arraySource {
+	return &arraySource{
+		prefix:  prefix,
+		indizes: indizes,
+		read:    read,
+	}
+}
+
+func newSeriesSource(prefix string, indizes map[string]int, readItems func(pid int32) (map[string][]string, error)) *arraySource {
+	return &arraySource{
+		prefix:    prefix,
+		indizes:   indizes,
+		readItems: readItems,
+	}
+}
+
+func (s *arraySource) Prefix() string {
+	return s.prefix
+}
+
+func (s *arraySource) Names() []string {
+	var names = make([]string, 0, len(s.indizes))
+
+	for n := range s.indizes {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (s *arraySource) Series() bool {
+	return s.readItems != nil
+}
+
+func (s *arraySource) Open(pid int32, metrics []Metric) error {
+	s.pid = pid
+	s.keys = make([]int, len(metrics))
+
+	for i, m := range metrics {
+		s.keys[i] = s.indizes[m.Name]
+	}
+
+	return nil
+}
+
+func (s *arraySource) Read() (*SourceData, error) {
+	var d = &SourceData{
+		Values: make([]float32, len(s.keys)),
+	}
+
+	if s.readItems == nil {
+		values, err := s.read(s.pid)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i, k := range s.keys {
+			f, _ := strconv.ParseFloat(values[k], 32)
+			d.Values[i] = float32(f)
+		}
+
+		return d, nil
+	}
+
+	items, err := s.readItems(s.pid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.Series = make(map[string][]float32, len(items))
+
+	for name, values := range items {
+		var series = make([]float32, len(s.keys))
+
+		for i, k := range s.keys {
+			f, _ := strconv.ParseFloat(values[k], 32)
+			series[i] = float32(f)
+			d.Values[i] += series[i]
+		}
+
+		d.Series[name] = series
+	}
+
+	return d, nil
+}
+
+func (s *arraySource) Close() error {
+	return nil
+}
@@ -0,0 +1,142 @@
+package tirion
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterMetricSource(func() MetricSource {
+		return &execSource{}
+	})
+}
+
+// execSource executes the commands of its metrics at every fetch.
+// A command prints one "key=value" pair per line and the metric "exec.<key>" gets the value of <key>.
+// The command of a metric is stated by its Source field which is executed by the shell and the PID of the program is handed over with the environment variable TIRION_PID.
+type execSource struct {
+	pid      int32
+	commands []string
+	metrics  []execMetric
+}
+
+type execMetric struct {
+	command int
+	key     string
+}
+
+func (s *execSource) Prefix() string {
+	return "exec."
+}
+
+func (s *execSource) Names() []string {
+	return nil
+}
+
+func (s *execSource) Series() bool {
+	return false
+}
+
+func (s *execSource) Open(pid int32, metrics []Metric) error {
+	var commands = make(map[string]int)
+	var missing []string
+
+	s.pid = pid
+	s.commands = nil
+	s.metrics = make([]execMetric, len(metrics))
+
+	for i, m := range metrics {
+		if m.Source == "" {
+			missing = append(missing, m.Name)
+
+			s.metrics[i] = execMetric{command: -1}
+
+			continue
+		}
+
+		c, ok := commands[m.Source]
+
+		if !ok {
+			c = len(s.commands)
+			commands[m.Source] = c
+
+			s.commands = append(s.commands, m.Source)
+		}
+
+		s.metrics[i] = execMetric{
+			command: c,
+			key:     strings.TrimPrefix(m.Name, s.Prefix()),
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("exec metrics without a command will be always 0: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (s *execSource) Read() (*SourceData, error) {
+	var outputs = make([]map[string]string, len(s.commands))
+
+	for i, c := range s.commands {
+		var err error
+
+		outputs[i], err = s.execute(c)
+
+		if err != nil {
+			return nil, fmt.Errorf("command \"%s\": %v", c, err)
+		}
+	}
+
+	var d = &SourceData{
+		Values: make([]float32, len(s.metrics)),
+	}
+
+	for i, m := range s.metrics {
+		if m.command == -1 {
+			continue
+		}
+
+		f, _ := strconv.ParseFloat(outputs[m.command][m.key], 32)
+		d.Values[i] = float32(f)
+	}
+
+	return d, nil
+}
+
+func (s *execSource) execute(command string) (map[string]string, error) {
+	// the shell parses the command, so arguments can be quoted
+	var cmd = exec.Command("/bin/sh", "-c", command)
+
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TIRION_PID=%d", s.pid))
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var values = make(map[string]string)
+	var scanner = bufio.NewScanner(bytes.NewReader(out))
+
+	for scanner.Scan() {
+		var kv = strings.SplitN(scanner.Text(), "=", 2)
+
+		if len(kv) == 2 {
+			values[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	return values, nil
+}
+
+func (s *execSource) Close() error {
+	return nil
+}
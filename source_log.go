@@ -0,0 +1,100 @@
+package tirion
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterMetricSource(func() MetricSource {
+		return &logSource{}
+	})
+}
+
+// logSource sets its metrics to numbers which are captured from the output of the program.
+// The Source field of a metric holds a regular expression with one capture group e.g. "processed (\d+) items".
+// A metric keeps the value of its last matching line.
+type logSource struct {
+	sync.Mutex
+
+	regexps []*regexp.Regexp
+	values  []float32
+}
+
+func (s *logSource) Prefix() string {
+	return "log."
+}
+
+func (s *logSource) Names() []string {
+	return nil
+}
+
+func (s *logSource) Series() bool {
+	return false
+}
+
+func (s *logSource) Open(pid int32, metrics []Metric) error {
+	// the output of the program can already be read while the source is opened
+	s.Lock()
+	defer s.Unlock()
+
+	var errs []string
+
+	s.regexps = make([]*regexp.Regexp, len(metrics))
+	s.values = make([]float32, len(metrics))
+
+	for i, m := range metrics {
+		re, err := regexp.Compile(m.Source)
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", m.Name, err))
+		} else if m.Source == "" || re.NumSubexp() != 1 {
+			errs = append(errs, fmt.Sprintf("%s: needs a regular expression with exactly one capture group", m.Name))
+		} else {
+			s.regexps[i] = re
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("log metrics will be always 0: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+func (s *logSource) ReadLine(stream string, line string) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, re := range s.regexps {
+		if re == nil {
+			continue
+		}
+
+		if m := re.FindStringSubmatch(line); m != nil {
+			if f, err := strconv.ParseFloat(m[1], 32); err == nil {
+				s.values[i] = float32(f)
+			}
+		}
+	}
+}
+
+func (s *logSource) Read() (*SourceData, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var d = &SourceData{
+		Values: make([]float32, len(s.values)),
+	}
+
+	copy(d.Values, s.values)
+
+	return d, nil
+}
+
+func (s *logSource) Close() error {
+	return nil
+}
@@ -0,0 +1,194 @@
+package tirion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zimmski/tirion/perf"
+	"github.com/zimmski/tirion/proc"
+)
+
+func init() {
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.all.", proc.AllIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadAllArray(int(pid))
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return &childrenSource{arraySource: newSeriesSource("proc.children.", proc.ChildrenIndizes, func(pid int32) (map[string][]string, error) {
+			return proc.ReadChildrenArrays(int(pid))
+		})}
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.fd.", proc.FdIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadFdArray(pidFolder(pid) + "fd")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.io.", proc.IOIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadIOArray(pidFolder(pid) + "io")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.sched.", proc.SchedIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadSchedArray(pidFolder(pid))
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.smaps_rollup.", proc.SmapsRollupIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadSmapsRollupArray(pidFolder(pid) + "smaps_rollup")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.stat.", proc.StatIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadStatArray(pidFolder(pid) + "stat")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.statm.", proc.StatmIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadStatmArray(pidFolder(pid) + "statm")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("proc.status.", proc.StatusIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadStatusArray(pidFolder(pid) + "status")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newSeriesSource("proc.task.", proc.TaskIndizes, func(pid int32) (map[string][]string, error) {
+			return proc.ReadTaskArrays(pidFolder(pid))
+		})
+	})
+
+	RegisterMetricSource(func() MetricSource {
+		// the CPU utilisation is computed out of the difference to the last fetch
+		var sysCPU proc.SysCPU
+
+		return newSeriesSource("sys.cpu.", proc.SysCPUIndizes, func(pid int32) (map[string][]string, error) {
+			return sysCPU.ReadArrays("/proc/stat")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newSeriesSource("sys.diskstats.", proc.DiskstatsIndizes, func(pid int32) (map[string][]string, error) {
+			return proc.ReadDiskstatsArrays("/proc/diskstats")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("sys.loadavg.", proc.LoadavgIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadLoadavgArray("/proc/loadavg")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("sys.meminfo.", proc.MeminfoIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadMeminfoArray("/proc/meminfo")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newSeriesSource("sys.netdev.", proc.NetdevIndizes, func(pid int32) (map[string][]string, error) {
+			return proc.ReadNetdevArrays("/proc/net/dev")
+		})
+	})
+	RegisterMetricSource(func() MetricSource {
+		return newArraySource("sys.pressure.", proc.PressureIndizes, func(pid int32) ([]string, error) {
+			return proc.ReadPressureArray("/proc/pressure/")
+		})
+	})
+
+	RegisterMetricSource(func() MetricSource {
+		return newPerfSource()
+	})
+}
+
+func pidFolder(pid int32) string {
+	return fmt.Sprintf("/proc/%d/", pid)
+}
+
+// childrenSource additionally tags spawned and exited child processes
+type childrenSource struct {
+	*arraySource
+
+	// processes of the last fetch
+	processes map[string]bool
+}
+
+func (s *childrenSource) Read() (*SourceData, error) {
+	d, err := s.arraySource.Read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.processes != nil {
+		for name := range d.Series {
+			if !s.processes[name] {
+				d.Tags = append(d.Tags, "process "+name+" spawned")
+			}
+		}
+
+		for name := range s.processes {
+			if _, ok := d.Series[name]; !ok {
+				d.Tags = append(d.Tags, "process "+name+" exited")
+			}
+		}
+	}
+
+	s.processes = make(map[string]bool, len(d.Series))
+
+	for name := range d.Series {
+		s.processes[name] = true
+	}
+
+	return d, nil
+}
+
+// perfSource reads the perf counters of the program
+type perfSource struct {
+	*arraySource
+
+	counters *perf.Counters
+}
+
+func newPerfSource() *perfSource {
+	var s = &perfSource{}
+
+	s.arraySource = newArraySource("perf.", perf.Indizes, func(pid int32) ([]string, error) {
+		return s.counters.ReadArray()
+	})
+
+	return s
+}
+
+func (s *perfSource) Open(pid int32, metrics []Metric) error {
+	s.arraySource.Open(pid, metrics)
+
+	var names = make([]string, len(metrics))
+
+	for i, m := range metrics {
+		names[i] = m.Name
+	}
+
+	s.counters = perf.OpenCounters(int(pid), names)
+
+	if len(s.counters.Errors) == 0 {
+		return nil
+	}
+
+	var errs []string
+
+	for name, err := range s.counters.Errors {
+		errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+	}
+
+	sort.Strings(errs)
+
+	return fmt.Errorf("perf counters are not available and will be always 0: %s", strings.Join(errs, ", "))
+}
+
+func (s *perfSource) Close() error {
+	if s.counters != nil {
+		s.counters.Close()
+	}
+
+	return nil
+}
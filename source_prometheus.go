@@ -0,0 +1,434 @@
+package tirion
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterMetricSource(func() MetricSource {
+		return &prometheusSource{}
+	})
+}
+
+// prometheusScrapeTimeout is the maximum duration of one scrape
+const prometheusScrapeTimeout = 2 * time.Second
+
+// prometheusSource scrapes endpoints which expose metrics in the Prometheus text format at every fetch.
+// The Source field of a metric holds the URL of the endpoint followed by an optional selector e.g.
+//
+//	http://127.0.0.1:8080/metrics http_requests_total{code="200",method=~"get|post"}
+//
+// If the selector is omitted the name of the metric without the "prometheus." prefix is used.
+// The values of all series matching the selector are summed up.
+type prometheusSource struct {
+	client    *http.Client
+	urls      []string
+	scraped   []bool
+	samples   [][]prometheusSample // samples of the last successful scrape of every endpoint
+	selectors []*prometheusSelector
+}
+
+type prometheusSelector struct {
+	url      int
+	name     string
+	matchers []prometheusMatcher
+}
+
+type prometheusMatcher struct {
+	label string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+type prometheusSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+func (s *prometheusSource) Prefix() string {
+	return "prometheus."
+}
+
+func (s *prometheusSource) Names() []string {
+	return nil
+}
+
+func (s *prometheusSource) Series() bool {
+	return false
+}
+
+func (s *prometheusSource) Open(pid int32, metrics []Metric) error {
+	var urls = make(map[string]int)
+	var errs []string
+
+	s.client = &http.Client{Timeout: prometheusScrapeTimeout}
+	s.urls = nil
+	s.selectors = make([]*prometheusSelector, len(metrics))
+
+	for i, m := range metrics {
+		var f = strings.Fields(m.Source)
+
+		if len(f) == 0 {
+			errs = append(errs, fmt.Sprintf("%s: no URL", m.Name))
+
+			continue
+		}
+
+		var selector = strings.TrimPrefix(m.Name, s.Prefix())
+
+		if len(f) > 1 {
+			selector = strings.TrimSpace(strings.TrimPrefix(m.Source, f[0]))
+		}
+
+		sel, err := parsePrometheusSelector(selector)
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", m.Name, err))
+
+			continue
+		}
+
+		u, ok := urls[f[0]]
+
+		if !ok {
+			u = len(s.urls)
+			urls[f[0]] = u
+
+			s.urls = append(s.urls, f[0])
+		}
+
+		sel.url = u
+		s.selectors[i] = sel
+	}
+
+	s.scraped = make([]bool, len(s.urls))
+	s.samples = nil
+
+	if len(errs) > 0 {
+		return fmt.Errorf("prometheus metrics will be always 0: %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// Read scrapes every endpoint. An endpoint which cannot be scraped keeps the samples of its last successful scrape, its error is returned together with the data.
+func (s *prometheusSource) Read() (*SourceData, error) {
+	var errs []string
+
+	if s.samples == nil {
+		s.samples = make([][]prometheusSample, len(s.urls))
+	}
+
+	for i, u := range s.urls {
+		samples, err := s.scrape(u)
+
+		if err != nil {
+			// the program might still be starting, so errors are ignored until the endpoint answered once
+			if s.scraped[i] {
+				errs = append(errs, fmt.Sprintf("scrape %s: %v", u, err))
+			}
+
+			continue
+		}
+
+		s.samples[i] = samples
+		s.scraped[i] = true
+	}
+
+	var d = &SourceData{
+		Values: make([]float32, len(s.selectors)),
+	}
+
+	for i, sel := range s.selectors {
+		if sel == nil {
+			continue
+		}
+
+		d.Values[i] = float32(sel.sum(s.samples[sel.url]))
+	}
+
+	if len(errs) > 0 {
+		return d, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+
+	return d, nil
+}
+
+func (s *prometheusSource) scrape(u string) ([]prometheusSample, error) {
+	resp, err := s.client.Get(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("status %v", resp.StatusCode)
+	}
+
+	return parsePrometheusText(resp.Body)
+}
+
+func (s *prometheusSource) Close() error {
+	return nil
+}
+
+// parsePrometheusText parses the Prometheus text exposition format
+func parsePrometheusText(r io.Reader) ([]prometheusSample, error) {
+	var samples []prometheusSample
+	var scanner = bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var sample = prometheusSample{
+			labels: make(map[string]string),
+		}
+
+		var i = strings.IndexAny(line, "{ \t")
+
+		if i == -1 {
+			return nil, fmt.Errorf("cannot parse line \"%s\"", line)
+		}
+
+		// tokens can be separated by any number of blanks
+		sample.name = line[:i]
+		line = strings.TrimLeft(line[i:], " \t")
+
+		if line == "" {
+			return nil, fmt.Errorf("missing value of \"%s\"", sample.name)
+		}
+
+		if line[0] == '{' {
+			var rest string
+			var err error
+
+			sample.labels, rest, err = parsePrometheusLabels(line[1:])
+
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse labels of \"%s\": %v", sample.name, err)
+			}
+
+			line = rest
+		}
+
+		// the value can be followed by a timestamp
+		var f = strings.Fields(line)
+
+		if len(f) == 0 {
+			return nil, fmt.Errorf("missing value of \"%s\"", sample.name)
+		}
+
+		v, err := strconv.ParseFloat(f[0], 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse value of \"%s\": %v", sample.name, err)
+		}
+
+		sample.value = v
+
+		samples = append(samples, sample)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// parsePrometheusLabels parses a label list after the opening '{' and returns the labels and the rest after the closing '}'
+func parsePrometheusLabels(s string) (map[string]string, string, error) {
+	var labels = make(map[string]string)
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+
+		if s == "" {
+			return nil, "", fmt.Errorf("missing '}'")
+		} else if s[0] == '}' {
+			return labels, s[1:], nil
+		}
+
+		var i = strings.IndexByte(s, '=')
+
+		if i == -1 {
+			return nil, "", fmt.Errorf("missing label value")
+		}
+
+		var name = strings.TrimSpace(s[:i])
+		var v = strings.TrimLeft(s[i+1:], " \t")
+
+		if v == "" || v[0] != '"' {
+			return nil, "", fmt.Errorf("missing label value")
+		}
+
+		value, rest, err := parsePrometheusString(v[1:])
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		labels[name] = value
+		s = rest
+	}
+}
+
+// parsePrometheusString parses an escaped string after its opening '"' and returns the unescaped string and the rest after the closing '"'
+func parsePrometheusString(s string) (string, string, error) {
+	var value []byte
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return string(value), s[i+1:], nil
+		case '\\':
+			i++
+
+			if i == len(s) {
+				return "", "", fmt.Errorf("unterminated escape sequence")
+			}
+
+			switch s[i] {
+			case 'n':
+				value = append(value, '\n')
+			default:
+				value = append(value, s[i])
+			}
+		default:
+			value = append(value, s[i])
+		}
+	}
+
+	return "", "", fmt.Errorf("missing '\"'")
+}
+
+var prometheusMatcherRegex = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*"`)
+
+// parsePrometheusSelector parses a selector like `name{label="value",other=~"regex"}`
+func parsePrometheusSelector(s string) (*prometheusSelector, error) {
+	var sel = &prometheusSelector{}
+	var i = strings.IndexByte(s, '{')
+
+	if i == -1 {
+		sel.name = strings.TrimSpace(s)
+
+		if sel.name == "" {
+			return nil, fmt.Errorf("empty selector")
+		}
+
+		return sel, nil
+	}
+
+	sel.name = strings.TrimSpace(s[:i])
+	s = s[i+1:]
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+
+		if s == "" {
+			return nil, fmt.Errorf("missing '}' in selector")
+		} else if s[0] == '}' {
+			if strings.TrimSpace(s[1:]) != "" {
+				return nil, fmt.Errorf("unexpected \"%s\" after selector", s[1:])
+			}
+
+			break
+		}
+
+		var m = prometheusMatcherRegex.FindStringSubmatch(s)
+
+		if m == nil {
+			return nil, fmt.Errorf("cannot parse label matcher \"%s\"", s)
+		}
+
+		value, rest, err := parsePrometheusString(s[len(m[0]):])
+
+		if err != nil {
+			return nil, err
+		}
+
+		var matcher = prometheusMatcher{
+			label: m[1],
+			op:    m[2],
+			value: value,
+		}
+
+		if matcher.op == "=~" || matcher.op == "!~" {
+			// like Prometheus the regular expression has to match the whole label value
+			matcher.re, err = regexp.Compile("^(?:" + value + ")$")
+
+			if err != nil {
+				return nil, fmt.Errorf("label matcher \"%s\": %v", m[1], err)
+			}
+		}
+
+		sel.matchers = append(sel.matchers, matcher)
+		s = rest
+	}
+
+	if sel.name == "" && len(sel.matchers) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+
+	return sel, nil
+}
+
+// sum returns the sum of all matching samples. Samples which are not finite numbers, like the NaN quantiles of an empty summary, are skipped.
+func (sel *prometheusSelector) sum(samples []prometheusSample) float64 {
+	var sum float64
+
+	for _, sample := range samples {
+		if sel.match(&sample) && !math.IsNaN(sample.value) && !math.IsInf(sample.value, 0) {
+			sum += sample.value
+		}
+	}
+
+	return sum
+}
+
+func (sel *prometheusSelector) match(sample *prometheusSample) bool {
+	if sel.name != "" && sel.name != sample.name {
+		return false
+	}
+
+	for _, m := range sel.matchers {
+		var v = sample.labels[m.label]
+
+		switch m.op {
+		case "=":
+			if v != m.value {
+				return false
+			}
+		case "!=":
+			if v == m.value {
+				return false
+			}
+		case "=~":
+			if !m.re.MatchString(v) {
+				return false
+			}
+		case "!~":
+			if m.re.MatchString(v) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
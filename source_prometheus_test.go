@@ -0,0 +1,137 @@
+package tirion
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePrometheusText(t *testing.T) {
+	var text = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+  # indented comment
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+metric_without_timestamp_and_labels 12.47
+empty_labels{} -3
+trailing_comma{a="1",} 1
+spaces { a = "x" } 2
+something_weird{problem="division by zero"} +Inf -3982045
+negative_infinity -Inf
+rpc_duration_seconds{quantile="0.5"} NaN
+`
+
+	samples, err := parsePrometheusText(strings.NewReader(text))
+
+	if err != nil {
+		t.Fatalf("parsePrometheusText failed: %v", err)
+	}
+
+	var expected = []prometheusSample{
+		{"http_requests_total", map[string]string{"method": "post", "code": "200"}, 1027},
+		{"http_requests_total", map[string]string{"method": "post", "code": "400"}, 3},
+		{"msdos_file_access_time_seconds", map[string]string{"path": `C:\DIR\FILE.TXT`, "error": "Cannot find file:\n\"FILE.TXT\""}, 1.458255915e9},
+		{"metric_without_timestamp_and_labels", map[string]string{}, 12.47},
+		{"empty_labels", map[string]string{}, -3},
+		{"trailing_comma", map[string]string{"a": "1"}, 1},
+		{"spaces", map[string]string{"a": "x"}, 2},
+		{"something_weird", map[string]string{"problem": "division by zero"}, math.Inf(1)},
+		{"negative_infinity", map[string]string{}, math.Inf(-1)},
+	}
+
+	if len(samples) != len(expected)+1 {
+		t.Fatalf("parsed %d samples, want %d", len(samples), len(expected)+1)
+	}
+
+	for i, e := range expected {
+		if !reflect.DeepEqual(samples[i], e) {
+			t.Errorf("sample[%d] = %+v, want %+v", i, samples[i], e)
+		}
+	}
+
+	// NaN is not equal to itself
+	if s := samples[len(expected)]; s.name != "rpc_duration_seconds" || !math.IsNaN(s.value) || s.labels["quantile"] != "0.5" {
+		t.Errorf("sample[%d] = %+v, want rpc_duration_seconds{quantile=\"0.5\"} NaN", len(expected), s)
+	}
+}
+
+func TestParsePrometheusTextInvalid(t *testing.T) {
+	var tests = []string{
+		"no_value",
+		"no_value{a=\"b\"}",
+		"bad_value abc",
+		"unclosed_labels{a=\"b\" 1",
+		"unquoted_label{a=b} 1",
+		"unterminated_string{a=\"b} 1",
+		"unterminated_escape{a=\"b\\",
+	}
+
+	for _, text := range tests {
+		if _, err := parsePrometheusText(strings.NewReader(text)); err == nil {
+			t.Errorf("parsePrometheusText(%q) did not fail", text)
+		}
+	}
+}
+
+func TestPrometheusSelector(t *testing.T) {
+	var samples = []prometheusSample{
+		{"requests", map[string]string{"code": "200", "method": "get"}, 10},
+		{"requests", map[string]string{"code": "200", "method": "post"}, 5},
+		{"requests", map[string]string{"code": "404", "method": "get"}, 2},
+		{"requests", map[string]string{"code": "500"}, math.Inf(1)},
+		{"latency", map[string]string{"quantile": "0.5"}, math.NaN()},
+		{"latency", map[string]string{"quantile": "0.9"}, 0.25},
+	}
+
+	var tests = []struct {
+		selector string
+		sum      float64
+	}{
+		{"requests", 17},
+		{`requests{code="200"}`, 15},
+		{`requests{code!="200"}`, 2},
+		{`requests{code=~"2.."}`, 15},
+		{`requests{code!~"2.."}`, 2},
+		{`requests{code=~"2"}`, 0},
+		{`requests{code="200", method="post"}`, 5},
+		{`requests{method=""}`, 0},
+		{`{method="get"}`, 12},
+		{"latency", 0.25},
+		{"unknown", 0},
+	}
+
+	for _, test := range tests {
+		sel, err := parsePrometheusSelector(test.selector)
+
+		if err != nil {
+			t.Errorf("parsePrometheusSelector(%q) failed: %v", test.selector, err)
+
+			continue
+		}
+
+		if sum := sel.sum(samples); sum != test.sum {
+			t.Errorf("sum of %q = %v, want %v", test.selector, sum, test.sum)
+		}
+	}
+}
+
+func TestPrometheusSelectorInvalid(t *testing.T) {
+	var tests = []string{
+		"",
+		"{}",
+		`requests{code="200"`,
+		`requests{code=200}`,
+		`requests{code~"200"}`,
+		`requests{code=~"("}`,
+		`requests{code="200"} rest`,
+	}
+
+	for _, selector := range tests {
+		if _, err := parsePrometheusSelector(selector); err == nil {
+			t.Errorf("parsePrometheusSelector(%q) did not fail", selector)
+		}
+	}
+}
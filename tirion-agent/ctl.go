@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zimmski/tirion"
+)
+
+// ctl sends one command to the control socket of a running agent and prints the result
+func ctl(args []string) {
+	var flags = flag.NewFlagSet("ctl", flag.ExitOnError)
+
+	var flagControl string
+	var flagHelp bool
+
+	flags.StringVar(&flagControl, "control", "", "Unix socket path of the running agent given by its -control argument")
+	flags.BoolVar(&flagHelp, "help", false, "Show this help")
+
+	flags.Parse(args)
+
+	if flagControl == "" || flags.NArg() == 0 || flagHelp {
+		fmt.Printf("Tirion agent v%s\n", tirion.Version)
+		fmt.Printf("usage:\n")
+		fmt.Printf("\t%s ctl -control <socket> <command> [argument]\n", os.Args[0])
+		fmt.Printf("commands\n")
+		fmt.Printf("\t%s [name]: Request a checkpoint\n", tirion.ControlCheckpoint)
+		fmt.Printf("\t%s <milliseconds>: Change how often metrics are fetched\n", tirion.ControlInterval)
+		fmt.Printf("\t%s [+|-]<MB>: Change the memory limit, 0 removes the limit\n", tirion.ControlLimitMemory)
+		fmt.Printf("\t%s [+|-]<seconds>: Change the time limit, 0 removes the limit\n", tirion.ControlLimitTime)
+		fmt.Printf("\t%s: Show the current metric values\n", tirion.ControlMetrics)
+		fmt.Printf("\t%s: Request the program to pause\n", tirion.ControlPause)
+		fmt.Printf("\t%s: Request the program to resume\n", tirion.ControlResume)
+		fmt.Printf("\t%s [reason]: Request a graceful stop and stop the run\n", tirion.ControlStop)
+		fmt.Printf("\t%s <tag>: Tag the run\n", tirion.ControlTag)
+		fmt.Printf("options\n")
+		flags.PrintDefaults()
+		fmt.Printf("\n")
+
+		if !flagHelp {
+			fmt.Printf("ERROR: Wrong arguments\n")
+		}
+
+		os.Exit(1)
+	}
+
+	result, err := tirion.ControlRequest(flagControl, flags.Arg(0), strings.Join(flags.Args()[1:], " "))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+
+		os.Exit(1)
+	}
+
+	if result != "" {
+		fmt.Println(result)
+	}
+}
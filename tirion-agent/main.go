@@ -6,56 +6,227 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/zimmski/tirion"
 )
 
+// stringList is a flag which can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+
+	return nil
+}
+
+// regexpList is a flag which can be given multiple times
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	var s = make([]string, len(*l))
+
+	for i, re := range *l {
+		s[i] = re.String()
+	}
+
+	return strings.Join(s, ", ")
+}
+
+func (l *regexpList) Set(value string) error {
+	re, err := regexp.Compile(value)
+
+	if err != nil {
+		return err
+	}
+
+	*l = append(*l, re)
+
+	return nil
+}
+
+// stopSignals holds the signals which can be used to stop a program
+var stopSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// splitProgramName splits the optional name of a program from a "[name=]value" argument
+func splitProgramName(value string) (string, string) {
+	var m = regexp.MustCompile("^([a-zA-Z0-9._-]+)=(.*)$").FindStringSubmatch(value)
+
+	if m == nil {
+		return "", value
+	}
+
+	return m[1], m[2]
+}
+
 func main() {
-	var flagExec string
-	var flagExecArguments string
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			ctl(os.Args[2:])
+
+			return
+		case "tag":
+			tag(os.Args[2:])
+
+			return
+		}
+	}
+
+	var flagControl string
+	var flagCwd string
+	var flagEnv stringList
+	var flagEnvClear bool
+	var flagExec stringList
+	var flagExecArguments stringList
+	var flagFollowChildren string
 	var flagHelp bool
 	var flagInterval int
 	var flagLimitMemory int
 	var flagLimitMemoryInterval int
 	var flagLimitTime int
+	var flagLimitTimeNotice int
+	var flagLogTags regexpList
 	var flagMetrics string
 	var flagMetricsFile string
 	var flagName string
-	var flagPid int
+	var flagPid stringList
+	var flagPidFile string
 	var flagSendInterval int
 	var flagServer string
-	var flagSocket string
+	var flagSocket stringList
+	var flagStdin string
+	var flagStopGrace int
+	var flagStopNotifyGrace int
+	var flagStopSignal string
 	var flagSubName string
 	var flagVerbose bool
+	var flagWaitForName string
 
 	flag.BoolVar(&flagHelp, "help", false, "Show this help")
-	flag.StringVar(&flagExec, "exec", "", "Execute this command")
-	flag.StringVar(&flagExecArguments, "exec-arguments", "", "Arguments for the command")
+	flag.StringVar(&flagControl, "control", "", "Unix socket path for controlling the running agent with \"tirion-agent ctl\"")
+	flag.StringVar(&flagCwd, "cwd", "", "Working directory of the command")
+	flag.Var(&flagEnv, "env", "Set the environment variable K=V for the command (can be given multiple times)")
+	flag.BoolVar(&flagEnvClear, "env-clear", false, "Do not pass the environment of the agent to the command")
+	flag.Var(&flagExec, "exec", "Execute this command given as [name=]command (can be given multiple times)")
+	flag.Var(&flagExecArguments, "exec-arguments", "Arguments for the command of the -exec at the same position which are split like a shell does (can be given multiple times)")
+	flag.StringVar(&flagFollowChildren, "follow-children", tirion.FollowNone, "What to monitor if the process of the program disappears: \"none\" to stop, \"child\" for its nearest running descendant or \"wait\" for a new process given by -pid-file or -wait-for-name")
 	flag.IntVar(&flagInterval, "interval", 250, "How often metrics are fetched (in milliseconds)")
 	flag.IntVar(&flagLimitMemory, "limit-memory", 0, "Limit the memory of the program and its children (in MB)")
 	flag.IntVar(&flagLimitMemoryInterval, "limit-memory-interval", 5, "Interval for checking the memory limit (in milliseconds)")
 	flag.IntVar(&flagLimitTime, "limit-time", 0, "Limit the runtime of the program (in seconds)")
-	flag.StringVar(&flagMetrics, "metrics", "", "Definition of needed program metrics")
+	flag.IntVar(&flagLimitTimeNotice, "limit-time-notice", 0, "Request the client to stop gracefully this many seconds before -limit-time is reached")
+	flag.Var(&flagLogTags, "log-tag", "Tag every line of the program's output matching this regular expression (can be given multiple times)")
+	flag.StringVar(&flagMetrics, "metrics", "", "Definition of needed program metrics given as name,type[,aggregation] separated by semicolons")
 	flag.StringVar(&flagMetricsFile, "metrics-file", "", "Definition of needed program metrics as a JSON file")
 	flag.StringVar(&flagName, "name", "", "The name of this run (defaults to exec)")
-	flag.IntVar(&flagPid, "pid", -1, "PID of program which should be monitored")
+	flag.Var(&flagPid, "pid", "PID of program which should be monitored given as [name=]pid (can be given multiple times)")
+	flag.StringVar(&flagPidFile, "pid-file", "", "Wait until this file contains the PID of the program which should be monitored")
 	flag.IntVar(&flagSendInterval, "send-interval", 5, "How often data is pushed to the server (in seconds)")
 	flag.StringVar(&flagServer, "server", "", "Server address for agent<-->server communication")
-	flag.StringVar(&flagSocket, "socket", "", "Unix socket path for client<-->agent communication")
+	flag.Var(&flagSocket, "socket", "Unix socket path for client<-->agent communication given as [name=]path (can be given multiple times). Executed programs get a generated path if none is given. The path is exported as TIRION_SOCKET to executed programs")
+	flag.StringVar(&flagStdin, "stdin", "", "Use this file as STDIN of the command")
+	flag.IntVar(&flagStopGrace, "stop-grace", 5, "How long the command can take to exit after the stop signal before it is killed (in seconds)")
+	flag.IntVar(&flagStopNotifyGrace, "stop-notify-grace", 0, "Request the client to stop and wait this long for the command to exit before the stop signal is sent (in seconds)")
+	flag.StringVar(&flagStopSignal, "stop-signal", "TERM", "Signal which is sent to stop the command. KILL kills the command immediately")
 	flag.StringVar(&flagSubName, "sub-name", "", "The subname of this run")
 	flag.BoolVar(&flagVerbose, "verbose", false, "Verbose output of what is going on")
+	flag.StringVar(&flagWaitForName, "wait-for-name", "", "Wait until a process with a command name (comm) matching this regular expression exists and monitor it")
 
 	flag.Parse()
 
-	if (flagPid == -1 && flagExec == "") || (flagMetrics == "" && flagMetricsFile == "") || flagHelp {
+	var programs []tirion.AgentProgram
+
+	for _, v := range flagPid {
+		name, pid := splitProgramName(v)
+
+		p, err := strconv.Atoi(pid)
+
+		if err != nil || p <= 0 {
+			panic(fmt.Sprintf("ERROR: Argument -pid \"%s\" must be a PID", v))
+		}
+
+		programs = append(programs, tirion.AgentProgram{Name: name, Pid: int32(p)})
+	}
+
+	if len(flagExecArguments) > len(flagExec) {
+		panic("ERROR: Every -exec-arguments needs an -exec at the same position")
+	}
+
+	for i, v := range flagExec {
+		// the command is used as it is, so its path can contain spaces
+		name, command := splitProgramName(v)
+
+		if command == "" {
+			panic(fmt.Sprintf("ERROR: Argument -exec \"%s\" has no command", v))
+		}
+
+		var args []string
+
+		if i < len(flagExecArguments) {
+			var err error
+
+			args, err = tirion.SplitArguments(flagExecArguments[i])
+
+			if err != nil {
+				panic(fmt.Sprintf("ERROR: Argument -exec-arguments \"%s\" cannot be split: %v", flagExecArguments[i], err))
+			}
+		}
+
+		programs = append(programs, tirion.AgentProgram{Name: name, Exec: command, ExecArguments: args})
+	}
+
+	// everything after "--" is the command with its arguments
+	if flag.NArg() > 0 {
+		if len(flagExec) > 0 || len(flagExecArguments) > 0 {
+			panic("ERROR: Use either -exec and -exec-arguments or a command after --")
+		}
+
+		programs = append(programs, tirion.AgentProgram{Exec: flag.Arg(0), ExecArguments: flag.Args()[1:]})
+	}
+
+	var executes = false
+
+	for _, p := range programs {
+		if p.Exec != "" {
+			executes = true
+
+			break
+		}
+	}
+
+	if (len(programs) == 0 && flagPidFile == "" && flagWaitForName == "") || (flagMetrics == "" && flagMetricsFile == "" && len(flagSocket) == 0 && !executes) || flagHelp {
 		fmt.Printf("Tirion agent v%s\n", tirion.Version)
 		fmt.Printf("usage:\n")
 		fmt.Printf("\t%s -pid <pid> -metrics <metrics> [other options]\n", os.Args[0])
 		fmt.Printf("\t%s -pid <pid> -metrics-file <metrics json file> [other options]\n", os.Args[0])
+		fmt.Printf("\t%s -pid-file <pid file> -metrics-file <metrics json file> [other options]\n", os.Args[0])
+		fmt.Printf("\t%s -wait-for-name <name regex> -metrics-file <metrics json file> [other options]\n", os.Args[0])
 		fmt.Printf("\t%s -exec <program> -metrics <metrics> [other options]\n", os.Args[0])
 		fmt.Printf("\t%s -exec <program> -metrics-file <metrics json file> [other options]\n", os.Args[0])
+		fmt.Printf("\t%s -exec <program> [-socket <socket>] [other options]\n", os.Args[0])
+		fmt.Printf("\t%s -exec <name>=<program> -exec <name>=<program> -socket <name>=<socket> [other options]\n", os.Args[0])
+		fmt.Printf("\t%s -metrics-file <metrics json file> [other options] -- <program> [arguments]\n", os.Args[0])
+		fmt.Printf("\t%s ctl -control <socket> <command> [argument]\n", os.Args[0])
+		fmt.Printf("\t%s tag -control <socket> <tag>\n", os.Args[0])
+		fmt.Printf("\t%s tag -server <server> -name <program> -run <run> <tag>\n", os.Args[0])
 		fmt.Printf("options\n")
 		flag.PrintDefaults()
 		fmt.Printf("\n")
@@ -67,9 +238,83 @@ func main() {
 		os.Exit(1)
 	}
 
-	if flagName == "" && flagExec != "" {
-		flagName = flagExec
+	if flagName == "" {
+		for _, p := range programs {
+			if p.Exec != "" {
+				flagName = p.Exec
+
+				break
+			}
+		}
+	}
+
+	if (flagPidFile != "" || flagWaitForName != "") && (len(programs) > 0 || (flagPidFile != "" && flagWaitForName != "")) {
+		panic("ERROR: -pid-file and -wait-for-name cannot be combined with other programs")
+	}
+
+	var waitForName *regexp.Regexp
+
+	if flagWaitForName != "" {
+		var err error
+
+		waitForName, err = regexp.Compile(flagWaitForName)
+
+		if err != nil {
+			panic(fmt.Sprintf("ERROR: Argument -wait-for-name is not a valid regular expression: %v", err))
+		}
+	}
+
+	if flagPidFile != "" || waitForName != nil {
+		programs = append(programs, tirion.AgentProgram{PidFile: flagPidFile, WaitForName: waitForName})
+	}
+
+	// the metrics of several programs are namespaced by the names of the programs
+	if len(programs) > 1 {
+		var rBadChars = regexp.MustCompile("[^a-zA-Z0-9._-]")
+
+		for i, p := range programs {
+			if p.Name != "" {
+				continue
+			} else if p.Exec != "" {
+				programs[i].Name = rBadChars.ReplaceAllLiteralString(filepath.Base(p.Exec), "-")
+			} else {
+				programs[i].Name = fmt.Sprintf("pid%d", p.Pid)
+			}
+		}
 	}
+
+	for _, v := range flagSocket {
+		name, socket := splitProgramName(v)
+		var found = false
+
+		for i, p := range programs {
+			if (name == "" && len(programs) == 1) || (name != "" && name == p.Name) {
+				if programs[i].Socket != "" {
+					panic(fmt.Sprintf("ERROR: Program \"%s\" has already a socket", p.Name))
+				}
+
+				programs[i].Socket = socket
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			panic(fmt.Sprintf("ERROR: Argument -socket \"%s\" must be given as <name>=<socket> with the name of a program", v))
+		}
+	}
+
+	switch flagFollowChildren {
+	case tirion.FollowNone, tirion.FollowChild:
+	case tirion.FollowWait:
+		if flagPidFile == "" && flagWaitForName == "" {
+			panic("ERROR: -follow-children wait only works in combination with -pid-file or -wait-for-name")
+		}
+	default:
+		panic(fmt.Sprintf("ERROR: Unknown -follow-children policy \"%s\"", flagFollowChildren))
+	}
+
 	if flagInterval <= 0 {
 		panic("ERROR: Argument -interval must be a positive number")
 	}
@@ -78,22 +323,40 @@ func main() {
 	}
 	if flagLimitTime < 0 {
 		panic("ERROR: Argument -limit-time must be a positive number")
-	} else if flagLimitTime > 0 && flagExec == "" {
+	} else if flagLimitTime > 0 && !executes {
 		panic("ERROR: -limit-time only works in combination with -exec")
 	}
+	if flagLimitTimeNotice < 0 {
+		panic("ERROR: Argument -limit-time-notice must be a positive number")
+	} else if flagLimitTimeNotice > 0 && flagLimitTimeNotice >= flagLimitTime {
+		panic("ERROR: -limit-time-notice must be smaller than -limit-time")
+	}
+	stopSignal, ok := stopSignals[strings.TrimPrefix(strings.ToUpper(flagStopSignal), "SIG")]
+
+	if !ok {
+		panic(fmt.Sprintf("ERROR: Unknown -stop-signal \"%s\"", flagStopSignal))
+	}
+	if flagStopGrace < 0 {
+		panic("ERROR: Argument -stop-grace must be a positive number")
+	}
+	if flagStopNotifyGrace < 0 {
+		panic("ERROR: Argument -stop-notify-grace must be a positive number")
+	}
 	if flagLimitMemory < 0 {
 		panic("ERROR: Argument -limit-memory must be a positive number")
-	} else if flagLimitMemory > 0 && flagExec == "" {
+	} else if flagLimitMemory > 0 && !executes {
 		panic("ERROR: -limit-memory only works in combination with -exec")
 	}
 	if flagLimitMemoryInterval <= 0 {
 		panic("ERROR: Argument -limit-memory-interval must be a positive number")
 	}
-
-	var execArguments []string
-
-	if flagExecArguments != "" {
-		execArguments = strings.Split(flagExecArguments, " ")
+	if (len(flagEnv) > 0 || flagEnvClear || flagCwd != "" || flagStdin != "") && !executes {
+		panic("ERROR: -env, -env-clear, -cwd and -stdin only work in combination with -exec")
+	}
+	for _, e := range flagEnv {
+		if !strings.Contains(e, "=") || strings.HasPrefix(e, "=") {
+			panic(fmt.Sprintf("ERROR: Argument -env \"%s\" must have the format K=V", e))
+		}
 	}
 
 	var metrics []tirion.Metric
@@ -104,13 +367,19 @@ func main() {
 		for _, m := range strings.Split(flagMetrics, ";") {
 			mi := strings.Split(m, ",")
 
-			if len(mi) != 2 {
+			if len(mi) != 2 && len(mi) != 3 {
 				panic("wrong format for metrics argument")
 			}
 
-			metrics = append(metrics, tirion.Metric{Name: mi[0], Type: mi[1]})
+			var metric = tirion.Metric{Name: mi[0], Type: mi[1]}
+
+			if len(mi) == 3 {
+				metric.Aggregate = mi[2]
+			}
+
+			metrics = append(metrics, metric)
 		}
-	} else {
+	} else if flagMetricsFile != "" {
 		jsonFile, err := ioutil.ReadFile(flagMetricsFile)
 
 		if err != nil {
@@ -129,16 +398,24 @@ func main() {
 		flagSubName,
 		flagServer,
 		int32(flagSendInterval),
-		int32(flagPid),
+		programs,
+		flagFollowChildren,
 		metrics,
-		flagExec,
-		execArguments,
+		flagEnv,
+		flagEnvClear,
+		flagCwd,
+		flagStdin,
 		int32(flagInterval),
-		flagSocket,
 		flagVerbose,
 		int64(flagLimitMemory),
 		int32(flagLimitMemoryInterval),
 		int32(flagLimitTime),
+		int32(flagLimitTimeNotice),
+		int32(flagStopNotifyGrace),
+		stopSignal,
+		int32(flagStopGrace),
+		flagLogTags,
+		flagControl,
 	)
 
 	a.Init()
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zimmski/tirion"
+)
+
+// tag tags a run at the current time from outside of the monitored program.
+// The tag is either sent to the control socket of the running agent or directly to the server.
+func tag(args []string) {
+	var flags = flag.NewFlagSet("tag", flag.ExitOnError)
+
+	var flagAttributes stringList
+	var flagCategory string
+	var flagControl string
+	var flagDuration time.Duration
+	var flagHelp bool
+	var flagName string
+	var flagRun int
+	var flagServer string
+	var flagSeverity string
+
+	flags.Var(&flagAttributes, "attribute", "Attribute of the tag given as key=value (can be given multiple times)")
+	flags.StringVar(&flagCategory, "category", "", "Category of the tag e.g. \"phase\", \"error\", \"gc\" or \"checkpoint\"")
+	flags.StringVar(&flagControl, "control", "", "Unix socket path of the running agent given by its -control argument")
+	flags.DurationVar(&flagDuration, "duration", 0, "Duration of the tag e.g. \"1.5s\"")
+	flags.BoolVar(&flagHelp, "help", false, "Show this help")
+	flags.StringVar(&flagName, "name", "", "The name of the run's program on the server")
+	flags.IntVar(&flagRun, "run", 0, "The ID of the run on the server")
+	flags.StringVar(&flagServer, "server", "", "Server address of the run")
+	flags.StringVar(&flagSeverity, "severity", "", "Severity of the tag which is one of \"debug\", \"info\", \"warning\" and \"error\"")
+
+	flags.Parse(args)
+
+	var t = tirion.Tag{
+		Tag:      strings.Join(flags.Args(), " "),
+		Category: flagCategory,
+		Severity: flagSeverity,
+		Duration: flagDuration,
+	}
+
+	if t.Tag == "" || (flagControl == "" && (flagServer == "" || flagName == "" || flagRun <= 0)) || (flagControl != "" && flagServer != "") || flagHelp {
+		fmt.Printf("Tirion agent v%s\n", tirion.Version)
+		fmt.Printf("usage:\n")
+		fmt.Printf("\t%s tag -control <socket> [tag options] <tag>\n", os.Args[0])
+		fmt.Printf("\t%s tag -server <server> -name <program> -run <run> [tag options] <tag>\n", os.Args[0])
+		fmt.Printf("options\n")
+		flags.PrintDefaults()
+		fmt.Printf("\n")
+
+		if !flagHelp {
+			fmt.Printf("ERROR: Wrong arguments\n")
+		}
+
+		os.Exit(1)
+	}
+
+	for _, a := range flagAttributes {
+		var kv = strings.SplitN(a, "=", 2)
+
+		if len(kv) != 2 {
+			fmt.Fprintf(os.Stderr, "ERROR: Argument -attribute \"%s\" must have the format key=value\n", a)
+
+			os.Exit(1)
+		}
+
+		if t.Attributes == nil {
+			t.Attributes = make(map[string]string)
+		}
+
+		t.Attributes[kv[0]] = kv[1]
+	}
+
+	var err = tirion.CheckTag(&t)
+
+	if err == nil {
+		if flagControl != "" {
+			var argument = t.Tag
+
+			// plain tags stay readable for the control socket
+			if t.Category != "" || t.Severity != "" || t.Duration != 0 || len(t.Attributes) != 0 {
+				data, _ := json.Marshal(t)
+
+				argument = string(data)
+			}
+
+			_, err = tirion.ControlRequest(flagControl, tirion.ControlTag, argument)
+		} else {
+			err = tagServer(flagServer, flagName, int32(flagRun), &t)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+
+		os.Exit(1)
+	}
+}
+
+// tagServer tags an ongoing run of the server at the current time of the server
+func tagServer(server string, name string, run int32, t *tirion.Tag) error {
+	var data = url.Values{
+		"tag":      {t.Tag},
+		"category": {t.Category},
+		"severity": {t.Severity},
+		"duration": {strconv.FormatInt(int64(t.Duration), 10)},
+	}
+
+	if len(t.Attributes) != 0 {
+		attributes, _ := json.Marshal(t.Attributes)
+
+		data.Set("attributes", string(attributes))
+	}
+
+	resp, err := http.PostForm(fmt.Sprintf("http://%s/program/%s/run/%d/tag", server, name, run), data)
+
+	if err != nil {
+		return fmt.Errorf("cannot do tag request %v", err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("tag request failed with status %v", resp.StatusCode)
+	}
+
+	var tagRequestResult tirion.MessageReturnTag
+
+	json.Unmarshal(body, &tagRequestResult)
+
+	if tagRequestResult.Error != "" {
+		return fmt.Errorf("tag request failed with error %v", tagRequestResult.Error)
+	}
+
+	return nil
+}
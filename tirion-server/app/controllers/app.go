@@ -69,6 +69,94 @@ func (c *App) ProgramRunMetric(programName string, runID int32, metricName strin
 	return c.RenderJson(metric)
 }
 
+func (c *App) ProgramRunLogs(programName string, runID int32) revel.Result {
+	run, err := app.Db.FindRun(programName, runID)
+
+	if err != nil {
+		panic(err)
+	} else if run == nil {
+		return c.NotFound("Run %d of program \"%s\" does not exists", runID, programName)
+	}
+
+	logs, err := app.Db.SearchLogsOfRun(run)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return c.RenderJson(logs)
+}
+
+func (c *App) ProgramRunLogInsert(programName string, runID int32) revel.Result {
+	var logs []tirion.MessageLog
+
+	var err = json.Unmarshal([]byte(c.Params.Get("logs")), &logs)
+
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("Parse logs: %v", err)})
+	}
+
+	err = app.Db.CreateLogs(runID, logs)
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("%+v", err)})
+	}
+
+	return c.RenderJson(tirion.MessageReturnInsert{Error: ""})
+}
+
+func (c *App) ProgramRunSpans(programName string, runID int32) revel.Result {
+	run, err := app.Db.FindRun(programName, runID)
+
+	if err != nil {
+		panic(err)
+	} else if run == nil {
+		return c.NotFound("Run %d of program \"%s\" does not exists", runID, programName)
+	}
+
+	stats, err := app.Db.SearchSpanStatsOfRun(run)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return c.RenderJson(stats)
+}
+
+func (c *App) ProgramRunSpanInsert(programName string, runID int32) revel.Result {
+	var spans []tirion.MessageSpan
+
+	var err = json.Unmarshal([]byte(c.Params.Get("spans")), &spans)
+
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("Parse spans: %v", err)})
+	}
+
+	err = app.Db.CreateSpans(runID, spans)
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("%+v", err)})
+	}
+
+	return c.RenderJson(tirion.MessageReturnInsert{Error: ""})
+}
+
+func (c *App) ProgramRunSeries(programName string, runID int32, metricName string) revel.Result {
+	run, err := app.Db.FindRun(programName, runID)
+
+	if err != nil {
+		panic(err)
+	} else if run == nil {
+		return c.NotFound("Run %d of program \"%s\" does not exists", runID, programName)
+	}
+
+	series, err := app.Db.SearchSeriesOfRun(run, metricName)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return c.RenderJson(series)
+}
+
 func (c *App) ProgramRunStart(programName string) revel.Result {
 	var interval, err = strconv.ParseInt(c.Params.Get("interval"), 10, 32)
 
@@ -82,6 +170,10 @@ func (c *App) ProgramRunStart(programName string) revel.Result {
 		Interval:      int32(interval),
 		Prog:          c.Params.Get("prog"),
 		ProgArguments: c.Params.Get("prog_arguments"),
+		ProgEnv:       c.Params.Get("prog_env"),
+		ProgEnvClear:  c.Params.Get("prog_env_clear") == "true",
+		ProgCwd:       c.Params.Get("prog_cwd"),
+		ProgStdin:     c.Params.Get("prog_stdin"),
 	}
 
 	if run.Name == "" {
@@ -123,8 +215,42 @@ func (c *App) ProgramRunInsert(programName string, runID int32) revel.Result {
 	return c.RenderJson(tirion.MessageReturnInsert{Error: ""})
 }
 
+func (c *App) ProgramRunMetricsAdd(programName string, runID int32) revel.Result {
+	var metrics []tirion.Metric
+
+	var err = json.Unmarshal([]byte(c.Params.Get("metrics")), &metrics)
+
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("Parse metrics: %v", err)})
+	}
+
+	err = app.Db.AddMetrics(runID, metrics)
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("%+v", err)})
+	}
+
+	return c.RenderJson(tirion.MessageReturnInsert{Error: ""})
+}
+
+func (c *App) ProgramRunSeriesInsert(programName string, runID int32) revel.Result {
+	var series []tirion.MessageSeries
+
+	var err = json.Unmarshal([]byte(c.Params.Get("series")), &series)
+
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("Parse series: %v", err)})
+	}
+
+	err = app.Db.CreateSeries(runID, series)
+	if err != nil {
+		return c.RenderJson(tirion.MessageReturnInsert{Error: fmt.Sprintf("%+v", err)})
+	}
+
+	return c.RenderJson(tirion.MessageReturnInsert{Error: ""})
+}
+
 func (c *App) ProgramRunStop(programName string, runID int32) revel.Result {
-	var err = app.Db.StopRun(runID)
+	var err = app.Db.StopRun(runID, c.Params.Get("reason"))
 
 	if err != nil {
 		return c.RenderJson(tirion.MessageReturnStop{Error: fmt.Sprintf("%+v", err)})
@@ -134,14 +260,56 @@ func (c *App) ProgramRunStop(programName string, runID int32) revel.Result {
 }
 
 func (c *App) ProgramRunTag(programName string, runID int32) revel.Result {
-	var t, err = strconv.ParseInt(c.Params.Get("time"), 10, 64)
-
 	var tag = tirion.Tag{
-		Tag:  tirion.PrepareTag(c.Params.Get("tag")),
-		Time: time.Unix(0, t),
+		Tag:      tirion.PrepareTag(c.Params.Get("tag")),
+		Category: c.Params.Get("category"),
+		Severity: c.Params.Get("severity"),
+	}
+
+	if d := c.Params.Get("duration"); d != "" {
+		duration, err := strconv.ParseInt(d, 10, 64)
+
+		if err != nil {
+			return c.RenderJson(tirion.MessageReturnTag{Error: fmt.Sprintf("duration is not a number: %+v", err)})
+		}
+
+		tag.Duration = time.Duration(duration)
+	}
+
+	if a := c.Params.Get("attributes"); a != "" {
+		if err := json.Unmarshal([]byte(a), &tag.Attributes); err != nil {
+			return c.RenderJson(tirion.MessageReturnTag{Error: fmt.Sprintf("attributes are not a JSON object: %+v", err)})
+		}
+	}
+
+	if err := tirion.CheckTag(&tag); err != nil {
+		return c.RenderJson(tirion.MessageReturnTag{Error: err.Error()})
+	}
+
+	if c.Params.Get("time") == "" {
+		// tags from outside of the agent land at the current time of an ongoing run
+		run, err := app.Db.FindRun(programName, runID)
+
+		if err != nil {
+			panic(err)
+		} else if run == nil {
+			return c.NotFound("Run %d of program \"%s\" does not exists", runID, programName)
+		} else if run.Stop != nil {
+			return c.RenderJson(tirion.MessageReturnTag{Error: fmt.Sprintf("run %d is already stopped", runID)})
+		}
+
+		tag.Time = time.Now()
+	} else {
+		t, err := strconv.ParseInt(c.Params.Get("time"), 10, 64)
+
+		if err != nil {
+			return c.RenderJson(tirion.MessageReturnTag{Error: fmt.Sprintf("time is not a timestamp: %+v", err)})
+		}
+
+		tag.Time = time.Unix(0, t)
 	}
 
-	err = app.Db.CreateTag(runID, &tag)
+	var err = app.Db.CreateTag(runID, &tag)
 
 	if err != nil {
 		return c.RenderJson(tirion.MessageReturnTag{Error: fmt.Sprintf("%+v", err)})
@@ -159,7 +327,7 @@ func (c *App) ProgramRunTags(programName string, runID int32) revel.Result {
 		return c.NotFound("Run %d of program \"%s\" does not exists", runID, programName)
 	}
 
-	tags, err := app.Db.SearchTagsOfRun(run)
+	tags, err := app.Db.SearchTagsOfRun(run, c.Params.Get("category"))
 	if err != nil {
 		panic(err)
 	}
@@ -24,6 +24,8 @@ function createChart(id, series, options) {
 								needed++;
 
 								$.getJSON(serie.options.url + "&from=" + serie.xData[serie.xData.length - 1], function(data) {
+									data = scaleData(data, serie.options.scale);
+
 									for (var i = 0; i < data.length; i++) {
 										serie.addPoint(data[i], false, false);
 									}
@@ -178,6 +180,8 @@ function createMultiChart(id, series, options) {
 									needed++;
 
 									$.getJSON(serie.options.url + "&from=" + serie.xData[serie.xData.length - 1], function(data) {
+										data = scaleData(data, serie.options.scale);
+
 										for (var i = 0; i < data.length; i++) {
 											serie.addPoint(data[i], false, false);
 										}
@@ -342,7 +346,7 @@ function createCombinedMultiChart(id, series, options) {
 	        height: step,
 			offset: 0,
 	        title: {
-	            text: serie.name
+	            text: serie.unit ? serie.name + ' (' + serie.unit + ')' : serie.name
 	        },
 			top: top
 	    });
@@ -351,14 +355,43 @@ function createCombinedMultiChart(id, series, options) {
 		top += step
 	});
 
+	var cPlotBands = [];
+
 	if (options.flags) {
-		cSeries.push({
-	        type: 'flags',
-	        data: options.flags,
-			//onSeries: 'dataseries',
-	        shape: 'squarepin',
-			y: -(top - step / 4)
-	    })
+		// every category of tags has its own flags series so the tags can be filtered by category
+		var categories = {};
+
+		$.each(options.flags, function(i, flag) {
+			var category = flag.category || 'tags';
+
+			if (! categories[category]) {
+				categories[category] = [];
+			}
+
+			categories[category].push(flag);
+
+			// tags with a duration are spans
+			if (flag.duration > 0) {
+				cPlotBands.push({
+					category: category,
+					color: 'rgba(68, 170, 213, 0.1)',
+					from: flag.x,
+					id: 'tag-' + i,
+					to: flag.x + flag.duration,
+				});
+			}
+		});
+
+		$.each(categories, function(category, flags) {
+			cSeries.push({
+				type: 'flags',
+				data: flags,
+				name: category,
+				//onSeries: 'dataseries',
+				shape: 'squarepin',
+				y: -(top - step / 4)
+			});
+		});
 	}
 
 	var cChart = new Highcharts.StockChart({
@@ -380,6 +413,8 @@ function createCombinedMultiChart(id, series, options) {
 								needed++;
 
 								$.getJSON(serie.options.url + "&from=" + serie.xData[serie.xData.length - 1], function(data) {
+									data = scaleData(data, serie.options.scale);
+
 									for (var i = 0; i < data.length; i++) {
 										serie.addPoint(data[i], false, false);
 									}
@@ -466,11 +501,44 @@ function createCombinedMultiChart(id, series, options) {
 
 		xAxis: {
 			minRange: 1, // one ms
+			plotBands: cPlotBands,
 		},
 
 		yAxis: cYAxis
 	});
 
+	if (options.tagFilter && categories) {
+		var filter = $('#' + options.tagFilter);
+
+		filter.append($('<option>').val('').text('All tags'));
+
+		$.each(categories, function(category) {
+			filter.append($('<option>').val(category).text(category));
+		});
+
+		filter.change(function() {
+			var category = filter.val();
+
+			$.each(cChart.series, function(i, serie) {
+				if (serie.type == 'flags') {
+					serie.setVisible(category == '' || serie.name == category, false);
+				}
+			});
+
+			$.each(cPlotBands, function(i, band) {
+				cChart.xAxis[0].removePlotBand(band.id);
+
+				if (category == '' || band.category == category) {
+					cChart.xAxis[0].addPlotBand(band);
+				}
+			});
+
+			cChart.redraw();
+		});
+
+		filter.parent().show();
+	}
+
 	multiA.push(cChart);
 	pushChart(cChart);
 
@@ -521,6 +589,83 @@ function createCombinedMultiChart(id, series, options) {
 	}, '');
 }
 
+function createHeatmap(id, rows, options) {
+	options = options || {};
+
+	var labelWidth = 200;
+	var cellHeight = 16;
+	var width = options.width || 1000;
+
+	var labels = Object.keys(rows).sort();
+	var times = [];
+	var columns = {};
+
+	$.each(labels, function(i, label) {
+		$.each(rows[label], function(j, point) {
+			if (columns[point[0]] === undefined) {
+				columns[point[0]] = true;
+				times.push(point[0]);
+			}
+		});
+	});
+
+	times.sort(function(a, b) { return a - b; });
+
+	$.each(times, function(i, t) {
+		columns[t] = i;
+	});
+
+	// the values of the rows are counters, so we display their change per second
+	var max = 0;
+	var rates = {};
+
+	$.each(labels, function(i, label) {
+		var data = rows[label];
+
+		rates[label] = [];
+
+		for (var j = 1; j < data.length; j++) {
+			var dt = (data[j][0] - data[j - 1][0]) / 1000.0;
+			var rate = dt > 0 ? (data[j][1] - data[j - 1][1]) / dt * (options.scale || 1) : 0;
+
+			rates[label].push([columns[data[j][0]], rate]);
+
+			if (rate > max) {
+				max = rate;
+			}
+		}
+	});
+
+	var canvas = document.createElement('canvas');
+	canvas.width = width;
+	canvas.height = labels.length * cellHeight + cellHeight;
+
+	var ctx = canvas.getContext('2d');
+	var cellWidth = (width - labelWidth) / Math.max(times.length, 1);
+
+	ctx.font = (cellHeight - 4) + 'px sans-serif';
+	ctx.textBaseline = 'middle';
+
+	$.each(labels, function(i, label) {
+		var y = i * cellHeight;
+
+		ctx.fillStyle = '#000';
+		ctx.fillText(label, 0, y + cellHeight / 2, labelWidth - 4);
+
+		$.each(rates[label], function(j, rate) {
+			var heat = max > 0 ? rate[1] / max : 0;
+
+			ctx.fillStyle = 'hsl(' + Math.round(240 - 240 * heat) + ', 100%, 50%)';
+			ctx.fillRect(labelWidth + rate[0] * cellWidth, y, Math.ceil(cellWidth), cellHeight - 1);
+		});
+	});
+
+	ctx.fillStyle = '#000';
+	ctx.fillText('max ' + Highcharts.numberFormat(max, 2) + (options.unit ? ' ' + options.unit : ''), labelWidth, labels.length * cellHeight + cellHeight / 2);
+
+	$('#' + id).append(canvas);
+}
+
 function pushChart(chart) {
 	if (window.chart) {
 		window.chart.push(chart);
@@ -530,6 +675,16 @@ function pushChart(chart) {
 	}
 }
 
+function scaleData(data, scale) {
+	if (! scale || scale == 1) {
+		return data;
+	}
+
+	return $.map(data, function(point) {
+		return [[point[0], point[1] * scale]];
+	});
+}
+
 function getJSONSynchron(url) {
 	var ret;
 
@@ -16,8 +16,13 @@ CREATE TABLE run (
 	metric_count INT NOT NULL,
 	prog TEXT NOT NULL,
 	prog_arguments TEXT NOT NULL,
+	prog_env TEXT NOT NULL DEFAULT '',
+	prog_env_clear BOOLEAN NOT NULL DEFAULT FALSE,
+	prog_cwd TEXT NOT NULL DEFAULT '',
+	prog_stdin TEXT NOT NULL DEFAULT '',
 	start TIMESTAMP NOT NULL,
 	stop TIMESTAMP,
+	stop_reason TEXT NOT NULL DEFAULT '',
 	PRIMARY KEY(id)
 );
 
@@ -0,0 +1,63 @@
+/* Migrations of existing databases which were created with an older postgresql_ddl.sql */
+
+/* Series and logs of runs */
+
+DO $$
+DECLARE
+	r RECORD;
+BEGIN
+	FOR r IN SELECT id FROM run LOOP
+		EXECUTE 'CREATE TABLE IF NOT EXISTS rs' || r.id || '(t TIMESTAMP NOT NULL, metric TEXT NOT NULL, series TEXT NOT NULL, value REAL NOT NULL, PRIMARY KEY(t, metric, series))';
+		EXECUTE 'CREATE TABLE IF NOT EXISTS rl' || r.id || '(id SERIAL, t TIMESTAMP NOT NULL, stream TEXT NOT NULL, line TEXT NOT NULL, PRIMARY KEY(id))';
+	END LOOP;
+END $$;
+
+/* Reproducible program execution */
+
+ALTER TABLE run ADD COLUMN IF NOT EXISTS prog_env TEXT NOT NULL DEFAULT '';
+ALTER TABLE run ADD COLUMN IF NOT EXISTS prog_env_clear BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE run ADD COLUMN IF NOT EXISTS prog_cwd TEXT NOT NULL DEFAULT '';
+ALTER TABLE run ADD COLUMN IF NOT EXISTS prog_stdin TEXT NOT NULL DEFAULT '';
+
+/* Stop reason of a run */
+
+ALTER TABLE run ADD COLUMN IF NOT EXISTS stop_reason TEXT NOT NULL DEFAULT '';
+
+/* Structured tags */
+
+DO $$
+DECLARE
+	r RECORD;
+BEGIN
+	FOR r IN SELECT id FROM run LOOP
+		EXECUTE 'ALTER TABLE rt' || r.id || ' ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT ''''';
+		EXECUTE 'ALTER TABLE rt' || r.id || ' ADD COLUMN IF NOT EXISTS severity TEXT NOT NULL DEFAULT ''''';
+		EXECUTE 'ALTER TABLE rt' || r.id || ' ADD COLUMN IF NOT EXISTS attributes TEXT NOT NULL DEFAULT ''''';
+		EXECUTE 'ALTER TABLE rt' || r.id || ' ADD COLUMN IF NOT EXISTS duration DOUBLE PRECISION NOT NULL DEFAULT 0';
+	END LOOP;
+END $$;
+
+/* Spans of programs */
+
+DO $$
+DECLARE
+	r RECORD;
+BEGIN
+	FOR r IN SELECT id FROM run LOOP
+		EXECUTE 'CREATE TABLE IF NOT EXISTS rp' || r.id || '(id SERIAL, name TEXT NOT NULL, parent TEXT NOT NULL, start TIMESTAMP NOT NULL, stop TIMESTAMP NOT NULL, unfinished BOOLEAN NOT NULL DEFAULT FALSE, PRIMARY KEY(id))';
+	END LOOP;
+END $$;
+
+/* Several tags with the same time */
+
+DO $$
+DECLARE
+	r RECORD;
+BEGIN
+	FOR r IN SELECT id FROM run LOOP
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'rt' || r.id AND column_name = 'id') THEN
+			EXECUTE 'ALTER TABLE rt' || r.id || ' DROP CONSTRAINT IF EXISTS rt' || r.id || '_pkey';
+			EXECUTE 'ALTER TABLE rt' || r.id || ' ADD COLUMN id SERIAL PRIMARY KEY';
+		END IF;
+	END LOOP;
+END $$;
@@ -7,28 +7,120 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/zimmski/tirion/codec"
 )
 
 // Version of Tirion.
 // The version is also used to dictated the used
 // protocol between agent and client communication.
-const Version = "0.3"
+const Version = "0.4"
+
+// capabilities holds the features of the client<->agent protocol which are implemented by this package.
+// Agent and client negotiate the capabilities which both sides support during the handshake.
+var capabilities = []string{"tag", "command", "structured-tag", "span"}
+
+// Commands of the agent to clients with the "command" capability
+const (
+	commandCheckpoint = 'c' // the program should dump its state. the optional argument is the name of the checkpoint
+	commandPause      = 'p' // the program should pause its workload
+	commandResume     = 'r' // the program should resume its workload
+	commandStop       = 's' // the program should stop gracefully. the argument is the reason
+)
 
 const tirionTagSize = 513
 
+// EnvSocket is the environment variable which holds the unix socket path of the agent for programs which are executed by the agent
+const EnvSocket = "TIRION_SOCKET"
+
+// Well-known categories of tags. Programs can use their own categories as well.
+const (
+	TagCategoryCheckpoint = "checkpoint"
+	TagCategoryError      = "error"
+	TagCategoryGC         = "gc"
+	TagCategoryPhase      = "phase"
+	TagCategorySpan       = "span"
+)
+
+// Severities of tags.
+const (
+	SeverityDebug   = "debug"
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// tagSeverities holds all useable tag severities.
+var tagSeverities = map[string]bool{
+	SeverityDebug:   true,
+	SeverityInfo:    true,
+	SeverityWarning: true,
+	SeverityError:   true,
+}
+
 // HighStockTag contains all data of a tag used with the HighStock library.
 type HighStockTag struct {
-	X     int64  `json:"x"`
-	Title string `json:"title"`
+	X          int64             `json:"x"`
+	Title      string            `json:"title"`
+	Text       string            `json:"text"` // tooltip of the flag
+	Category   string            `json:"category"`
+	Severity   string            `json:"severity"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Duration   int64             `json:"duration"` // in milliseconds
+}
+
+// SpanStats contains the statistics of all spans with the same name of a run. All durations are in milliseconds.
+type SpanStats struct {
+	Name       string  `json:"name"`
+	Count      int64   `json:"count"`
+	Total      float64 `json:"total"`
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+	Avg        float64 `json:"avg"`
+	Unfinished int64   `json:"unfinished"` // number of spans which were not ended by the program
+}
+
+// LogLine contains a line of the program's output.
+type LogLine struct {
+	X      int64  `json:"x"`
+	Stream string `json:"stream"`
+	Line   string `json:"line"`
 }
 
 // Metric contains all data of a metric.
 type Metric struct {
-	Name string
-	Type string
+	Name        string
+	Type        string
+	Kind        string  `json:",omitempty"` // optional kind of the metric e.g. "counter" or "gauge"
+	Unit        string  `json:",omitempty"` // optional unit of the metric's values after they are multiplied by Scale
+	Description string  `json:",omitempty"` // optional human readable description of the metric
+	Scale       float64 `json:",omitempty"` // optional factor to convert raw values into Unit. 0 is the same as 1
+	Expression  string  `json:",omitempty"` // optional expression over other metrics which makes this a derived metric
+	Source      string  `json:",omitempty"` // optional parameter for the metric source of an external metric e.g. the command of an exec metric
+	Series      bool    `json:",omitempty"` // states that the metric is recorded as a set of dynamically named series. the metric's own values are the sum of all series
+	Aggregate   string  `json:",omitempty"` // optional aggregation of an internal metric over all clients of the program. Default is "sum"
+}
+
+// Aggregations of an internal metric over all clients of a program.
+const (
+	AggregateSum    = "sum"    // sum of all clients
+	AggregateMin    = "min"    // minimum of all clients
+	AggregateMax    = "max"    // maximum of all clients
+	AggregateAvg    = "avg"    // average of all clients
+	AggregateWorker = "worker" // every client is recorded as its own series named by its PID and comm. the metric's own values are the sum of all clients
+)
+
+// metricAggregates holds all useable aggregations of internal metrics.
+var metricAggregates = map[string]bool{
+	AggregateSum:    true,
+	AggregateMin:    true,
+	AggregateMax:    true,
+	AggregateAvg:    true,
+	AggregateWorker: true,
 }
 
 // metricTypes holds all useable metric types.
@@ -37,6 +129,12 @@ var metricTypes = map[string]bool{
 	"float": true,
 }
 
+// metricKinds holds all useable metric kinds.
+var metricKinds = map[string]bool{
+	"counter": true,
+	"gauge":   true,
+}
+
 // Program contains all data of a program.
 type Program struct {
 	Name string
@@ -51,24 +149,191 @@ type Run struct {
 	Metrics       []Metric
 	MetricCount   int32
 	Prog          string
-	ProgArguments string
+	ProgArguments string // shell quoted arguments of the program
+	ProgEnv       string // shell quoted environment variables which were set for the program
+	ProgEnvClear  bool   // states that the program was started without the environment of the agent
+	ProgCwd       string // working directory of the program
+	ProgStdin     string // file which was used as STDIN of the program
 	Start         *time.Time
 	Stop          *time.Time
+	StopReason    string // stage which ended the executed program e.g. "signal", see the Stop constants
+}
+
+// Command returns a shell command which reproduces the execution of the run's program.
+func (r *Run) Command() string {
+	var c []string
+
+	if r.ProgCwd != "" {
+		c = append(c, "cd "+QuoteArguments([]string{r.ProgCwd})+" &&")
+	}
+	if r.ProgEnvClear {
+		c = append(c, "env -i")
+	} else if r.ProgEnv != "" {
+		c = append(c, "env")
+	}
+	if r.ProgEnv != "" {
+		c = append(c, r.ProgEnv)
+	}
+
+	c = append(c, QuoteArguments([]string{r.Prog}))
+
+	if r.ProgArguments != "" {
+		c = append(c, r.ProgArguments)
+	}
+	if r.ProgStdin != "" {
+		c = append(c, "< "+QuoteArguments([]string{r.ProgStdin}))
+	}
+
+	return strings.Join(c, " ")
+}
+
+// QuoteArguments joins arguments with spaces and quotes them for a POSIX shell if necessary.
+func QuoteArguments(args []string) string {
+	var safeArgument = regexp.MustCompile(`^[a-zA-Z0-9_@%+=:,./-]+$`)
+	var quoted = make([]string, len(args))
+
+	for i, a := range args {
+		if safeArgument.MatchString(a) {
+			quoted[i] = a
+		} else {
+			quoted[i] = "'" + strings.Replace(a, "'", `'\''`, -1) + "'"
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// SplitArguments splits a command line at unquoted whitespace like a POSIX shell.
+// Single quotes, double quotes and backslashes are handled, so the result of QuoteArguments can be split again.
+func SplitArguments(s string) ([]string, error) {
+	var args []string
+	var arg []rune
+	var inArg bool
+	var quote rune
+	var escape bool
+
+	for _, r := range s {
+		switch {
+		case escape:
+			// within double quotes a backslash only escapes characters which are special there
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				arg = append(arg, '\\')
+			}
+			// an escaped newline continues the line
+			if r != '\n' {
+				arg = append(arg, r)
+			}
+
+			escape = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg = append(arg, r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' {
+				escape = true
+			} else {
+				arg = append(arg, r)
+			}
+		case r == '\\':
+			escape = true
+			inArg = true
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(arg))
+
+				arg = arg[:0]
+				inArg = false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+
+	if escape {
+		return nil, fmt.Errorf("trailing backslash in \"%s\"", s)
+	} else if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in \"%s\"", s)
+	}
+
+	if inArg {
+		args = append(args, string(arg))
+	}
+
+	return args, nil
 }
 
 // Tag contains all data of a tag.
+// A tag with a duration is a span from its time until its time plus the duration.
 type Tag struct {
-	Time time.Time
-	Tag  string
+	Time       time.Time
+	Tag        string
+	Category   string            `json:",omitempty"` // optional category e.g. "phase", "error", "gc" or "checkpoint"
+	Severity   string            `json:",omitempty"` // optional severity which is one of "debug", "info", "warning" and "error"
+	Attributes map[string]string `json:",omitempty"` // optional key/value attributes
+	Duration   time.Duration     `json:",omitempty"` // optional duration of the tag
+}
+
+// String formats the tag as plain text e.g. "[phase, warning] load users=10 (1.5s)"
+func (t *Tag) String() string {
+	var s = t.Tag
+
+	var head []string
+
+	if t.Category != "" {
+		head = append(head, t.Category)
+	}
+	if t.Severity != "" {
+		head = append(head, t.Severity)
+	}
+	if len(head) != 0 {
+		s = "[" + strings.Join(head, ", ") + "] " + s
+	}
+
+	var keys = make([]string, 0, len(t.Attributes))
+
+	for k := range t.Attributes {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s += " " + k + "=" + t.Attributes[k]
+	}
+
+	if t.Duration != 0 {
+		s += " (" + t.Duration.String() + ")"
+	}
+
+	return s
+}
+
+// spanMessage is sent by a client with the "span" capability to start ('S') and to end ('E') a span.
+type spanMessage struct {
+	ID     int64
+	Parent int64  `json:",omitempty"` // ID of the parent span or 0. only used to start a span
+	Name   string `json:",omitempty"` // only used to start a span
+	Time   time.Time
 }
 
 // Tirion contains all common data of a Tirion object like Agent and Client.
 type Tirion struct {
-	fd        net.Conn
-	Running   bool // states if the given Tirion object is still running
-	socket    string
-	verbose   bool
-	logPrefix string
+	capabilities []string // capabilities negotiated with the other side of the socket
+	conn         *codec.Conn
+	fd           net.Conn
+	Running      bool // states if the given Tirion object is still running
+	socket       string
+	verbose      bool
+	logPrefix    string
 }
 
 // CheckMetrics validates a array of metrics.
@@ -79,7 +344,7 @@ func CheckMetrics(metrics []Metric) error {
 		return fmt.Errorf("maximum of %d metrics allowed", math.MaxInt32)
 	}
 
-	var metricNameRegex = regexp.MustCompile("[^a-zA-Z0-9.-_]")
+	var metricNameRegex = regexp.MustCompile("[^a-zA-Z0-9._/-]")
 	var metricNames = make(map[string]int32)
 
 	for i, m := range metrics {
@@ -88,18 +353,81 @@ func CheckMetrics(metrics []Metric) error {
 		} else if len(m.Name) > 256 {
 			return fmt.Errorf("name of metric[%d] exceeds maximum of 256 characters", i)
 		} else if metricNameRegex.MatchString(m.Name) {
-			return fmt.Errorf("name  of metric[%d] uses illegal characters. Only a-z, A-Z, 0-9, ., - and _ are allowed", i)
+			return fmt.Errorf("name  of metric[%d] uses illegal characters. Only a-z, A-Z, 0-9, ., -, _ and / are allowed", i)
 		} else if v, ok := metricNames[m.Name]; ok {
 			return fmt.Errorf("name \"%s\" of metric[%d] alreay used for metric[%d]", m.Name, i, v)
 		} else if m.Type == "" {
 			return fmt.Errorf("no type defined for metric[%d]", i)
 		} else if _, ok := metricTypes[m.Type]; !ok {
 			return fmt.Errorf("unknown metric type \"%s\" for metric[%d]", m.Type, i)
+		} else if _, ok := metricKinds[m.Kind]; m.Kind != "" && !ok {
+			return fmt.Errorf("unknown metric kind \"%s\" for metric[%d]", m.Kind, i)
+		} else if len(m.Unit) > 32 {
+			return fmt.Errorf("unit of metric[%d] exceeds maximum of 32 characters", i)
+		} else if strings.ContainsAny(m.Unit, "\t\n") {
+			return fmt.Errorf("unit of metric[%d] must not contain tabs or newlines", i)
+		} else if len(m.Description) > 1024 {
+			return fmt.Errorf("description of metric[%d] exceeds maximum of 1024 characters", i)
+		} else if m.Scale < 0.0 || math.IsNaN(m.Scale) || math.IsInf(m.Scale, 0) {
+			return fmt.Errorf("scale of metric[%d] must be a positive finite number", i)
+		} else if _, ok := metricAggregates[m.Aggregate]; m.Aggregate != "" && !ok {
+			return fmt.Errorf("unknown aggregation \"%s\" for metric[%d]", m.Aggregate, i)
+		} else if m.Aggregate != "" && m.Expression != "" {
+			return fmt.Errorf("derived metric[%d] cannot be aggregated over clients", i)
 		}
 
 		metricNames[m.Name] = int32(i)
 	}
 
+	for i, m := range metrics {
+		if m.Expression == "" {
+			continue
+		}
+
+		e, err := ParseExpression(m.Expression)
+
+		if err != nil {
+			return fmt.Errorf("expression of metric[%d] is invalid: %v", i, err)
+		}
+
+		for _, n := range e.Metrics {
+			if v, ok := metricNames[n]; !ok {
+				return fmt.Errorf("expression of metric[%d] references unknown metric \"%s\"", i, n)
+			} else if metrics[v].Expression != "" && v >= int32(i) {
+				return fmt.Errorf("expression of metric[%d] references derived metric \"%s\" which is not defined before", i, n)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckTag validates the structure of a tag.
+func CheckTag(tag *Tag) error {
+	var nameRegex = regexp.MustCompile("^[a-zA-Z0-9._-]+$")
+
+	if tag.Tag == "" {
+		return fmt.Errorf("no message defined for tag")
+	} else if len(tag.Category) > 64 {
+		return fmt.Errorf("category of tag exceeds maximum of 64 characters")
+	} else if tag.Category != "" && !nameRegex.MatchString(tag.Category) {
+		return fmt.Errorf("category of tag uses illegal characters. Only a-z, A-Z, 0-9, ., - and _ are allowed")
+	} else if _, ok := tagSeverities[tag.Severity]; tag.Severity != "" && !ok {
+		return fmt.Errorf("unknown tag severity \"%s\"", tag.Severity)
+	} else if tag.Duration < 0 {
+		return fmt.Errorf("duration of tag must be positive")
+	} else if len(tag.Attributes) > 32 {
+		return fmt.Errorf("maximum of 32 attributes per tag allowed")
+	}
+
+	for k, v := range tag.Attributes {
+		if len(k) > 64 || !nameRegex.MatchString(k) {
+			return fmt.Errorf("attribute key \"%s\" of tag must have at most 64 characters of a-z, A-Z, 0-9, ., - and _", k)
+		} else if len(v) > 512 {
+			return fmt.Errorf("value of attribute \"%s\" of tag exceeds maximum of 512 characters", k)
+		}
+	}
+
 	return nil
 }
 
@@ -126,21 +454,30 @@ func (t *Tirion) initSigHandler() {
 }
 
 func (t *Tirion) receive() (string, error) {
-	var buf = make([]byte, 4096)
+	if t.conn == nil {
+		return "", fmt.Errorf("not connected")
+	}
 
-	nr, err := t.fd.Read(buf)
+	return t.conn.ReadMessage()
+}
 
-	if err != nil {
-		return "", err
+func (t *Tirion) send(msg string) error {
+	if t.conn == nil {
+		return fmt.Errorf("not connected")
 	}
 
-	return strings.Trim(string(buf[0:nr]), "\n"), nil
+	return t.conn.WriteMessage(msg)
 }
 
-func (t *Tirion) send(msg string) error {
-	_, err := t.fd.Write([]byte(msg + "\n"))
+// hasCapability states if a capability was negotiated with the other side of the socket
+func (t *Tirion) hasCapability(capability string) bool {
+	for _, c := range t.capabilities {
+		if c == capability {
+			return true
+		}
+	}
 
-	return err
+	return false
 }
 
 func (t *Tirion) m(messageType string, format string, a ...interface{}) (n int, err error) {
@@ -0,0 +1,72 @@
+package tirion
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArguments(t *testing.T) {
+	var tests = []struct {
+		s    string
+		args []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"md5sum /dev/random", []string{"md5sum", "/dev/random"}},
+		{"  -v\t-r  5 \n", []string{"-v", "-r", "5"}},
+		{"'foo bar' baz", []string{"foo bar", "baz"}},
+		{`"foo bar" baz`, []string{"foo bar", "baz"}},
+		{`foo\ bar baz`, []string{"foo bar", "baz"}},
+		{`a'b c'd`, []string{"ab cd"}},
+		{`'' ""`, []string{"", ""}},
+		{`'it'\''s'`, []string{"it's"}},
+		{`"say \"hi\""`, []string{`say "hi"`}},
+		{`'no \escape'`, []string{`no \escape`}},
+		{`"no \escape"`, []string{`no \escape`}},
+		{`"\$HOME \` + "`" + `"`, []string{"$HOME `"}},
+		{"a\\\nb", []string{"ab"}},
+		{`"a\\b"`, []string{`a\b`}},
+		{`"single ' inside"`, []string{"single ' inside"}},
+		{`'double " inside'`, []string{`double " inside`}},
+		{`--name=a\=b`, []string{"--name=a=b"}},
+	}
+
+	for _, test := range tests {
+		args, err := SplitArguments(test.s)
+
+		if err != nil {
+			t.Errorf("SplitArguments(%q) failed: %v", test.s, err)
+		} else if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("SplitArguments(%q) = %q, want %q", test.s, args, test.args)
+		}
+	}
+}
+
+func TestSplitArgumentsInvalid(t *testing.T) {
+	for _, s := range []string{`'unterminated`, `"unterminated`, `trailing\`, `"escaped quote\"`} {
+		if _, err := SplitArguments(s); err == nil {
+			t.Errorf("SplitArguments(%q) did not fail", s)
+		}
+	}
+}
+
+func TestQuoteArgumentsRoundTrip(t *testing.T) {
+	var tests = [][]string{
+		{"md5sum", "/dev/random"},
+		{"foo bar", "it's", ""},
+		{`back\slash`, `"quoted"`, "tab\there", "new\nline"},
+		{"$HOME", "*", "a;b", "--opt=1,2"},
+	}
+
+	for _, args := range tests {
+		var quoted = QuoteArguments(args)
+
+		split, err := SplitArguments(quoted)
+
+		if err != nil {
+			t.Errorf("SplitArguments(%q) failed: %v", quoted, err)
+		} else if !reflect.DeepEqual(split, args) {
+			t.Errorf("SplitArguments(QuoteArguments(%q)) = %q", args, split)
+		}
+	}
+}